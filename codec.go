@@ -0,0 +1,168 @@
+package combi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Names of the built in codecs
+const (
+	CodecXML  = "xml"
+	CodecJSON = "json"
+	CodecYAML = "yaml"
+)
+
+/*
+Codec encodes and decodes request / response values, the commander codec
+(--codec) sets the wire format used by transports and request files. Output
+formats are chosen separately (-o), registered codecs are available as output
+formats of the same name
+*/
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	ContentType() string
+}
+
+// XMLCodec encodes values as XML, values encoding/xml cannot handle directly (maps, lists) are wrapped in a <result> element
+type XMLCodec struct {
+	// Indent pretty prints nested elements when set
+	Indent string
+}
+
+// Marshal encodes v as XML
+func (c XMLCodec) Marshal(v interface{}) ([]byte, error) {
+	if c.Indent != "" {
+		return xml.MarshalIndent(xmlValue(v), "", c.Indent)
+	}
+
+	return xml.Marshal(xmlValue(v))
+}
+
+// Unmarshal decodes XML into v
+func (c XMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// ContentType returns the XML media type
+func (c XMLCodec) ContentType() string {
+	return "application/xml"
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec struct {
+	// Indent pretty prints nested values when set
+	Indent string
+}
+
+// Marshal encodes v as JSON
+func (c JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	if c.Indent != "" {
+		return json.MarshalIndent(v, "", c.Indent)
+	}
+
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON into v
+func (c JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ContentType returns the JSON media type
+func (c JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// YAMLCodec encodes values as YAML
+type YAMLCodec struct{}
+
+// Marshal encodes v as YAML
+func (c YAMLCodec) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// Unmarshal decodes YAML into v
+func (c YAMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// ContentType returns the YAML media type
+func (c YAMLCodec) ContentType() string {
+	return "application/yaml"
+}
+
+// defaultCodecs returns the built in codecs keyed by name
+func defaultCodecs() map[string]Codec {
+	return map[string]Codec{
+		CodecXML:  XMLCodec{},
+		CodecJSON: JSONCodec{},
+		CodecYAML: YAMLCodec{},
+	}
+}
+
+// isXMLCodec reports whether the codec produces XML, which needs a named root element
+func isXMLCodec(codec Codec) bool {
+	return strings.Contains(codec.ContentType(), "xml")
+}
+
+// CodecForContentType returns the registered codec handling the media type
+func (c *Commander) CodecForContentType(contentType string) (Codec, bool) {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+
+	c.RLock()
+	defer c.RUnlock()
+	for _, codec := range c.codecs {
+		if codec.ContentType() == mediaType {
+			return codec, true
+		}
+	}
+
+	// structured syntax suffixes, e.g. application/problem+json
+	for name, codec := range c.codecs {
+		if strings.HasSuffix(mediaType, "+"+name) {
+			return codec, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+LoadRequestFile decodes the file set with --request-file (or the shell setting)
+into req, the codec is chosen by file extension, falling back to the commander
+codec. It does nothing when no request file is set
+*/
+func (c *Commander) LoadRequestFile(req interface{}) error {
+	path := c.RequestFile()
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read request file: %w", err)
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "yml" {
+		ext = CodecYAML
+	}
+	codec, err := c.Codec(ext)
+	if err != nil {
+		codec = c.DefaultCodec()
+	}
+
+	err = codec.Unmarshal(data, req)
+	if err != nil {
+		return fmt.Errorf("unable to decode request file: %w", err)
+	}
+
+	return nil
+}
@@ -1,9 +1,12 @@
 package combi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/abiosoft/ishell.v2"
@@ -11,29 +14,109 @@ import (
 
 /*
 StaticExec provides a function signature to provide static cli execution (not within shell)
-matches cobra command Run signature
+matches cobra command Run signature, with the context for the invocation
 */
-type StaticExec func(command *Command, cmd *cobra.Command, args []string) error
+type StaticExec func(ctx context.Context, command *Command, cmd *cobra.Command, args []string) error
 
 // ShellExec provides a function signature to provide shell based command execution
-type ShellExec func(command *Command, c *ishell.Context) error
+type ShellExec func(ctx context.Context, command *Command, c *ishell.Context) error
 
 // RegisterFunc should register the command and bind any required variables to the request object
 type RegisterFunc func(parentCmd *cobra.Command, cmd *Command) error
 
-// RequestHandler is the function called to make the request and populate the response
-type RequestHandler func(request interface{}, response interface{}) error
+/*
+RequestHandler is the function called to make the request and populate the
+response, it should give up and return when the context is cancelled
+*/
+type RequestHandler func(ctx context.Context, request interface{}, response interface{}) error
+
+/*
+Middleware wraps a RequestHandler, returning a handler which may inspect or
+alter the request, response and error around calling next. The executing
+Command is available from the context with CommandFromContext
+*/
+type Middleware func(next RequestHandler) RequestHandler
+
+// Chain wraps the handler with the middleware, the first middleware given is the outermost
+func Chain(handler RequestHandler, middleware ...Middleware) RequestHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
 
-// ResponseHandler is the function called to handle presentation of the response
-type ResponseHandler func(response interface{}) error
+// ResponseHandler is the function called to handle presentation of the response to the output
+type ResponseHandler func(out *Output, response interface{}) error
 
 // CommandHook provides a hook signature to provide hooks to be run before or after all request handlers
-type CommandHook func(c *Command) error
+type CommandHook func(ctx context.Context, c *Command) error
+
+// LegacyStaticExec is the StaticExec signature prior to context support
+type LegacyStaticExec func(command *Command, cmd *cobra.Command, args []string) error
+
+// LegacyShellExec is the ShellExec signature prior to context support
+type LegacyShellExec func(command *Command, c *ishell.Context) error
+
+// LegacyRequestHandler is the RequestHandler signature prior to context support
+type LegacyRequestHandler func(request interface{}, response interface{}) error
+
+// LegacyCommandHook is the CommandHook signature prior to context support
+type LegacyCommandHook func(c *Command) error
+
+// AdaptStaticExec converts a LegacyStaticExec to a StaticExec, the context is ignored
+func AdaptStaticExec(f LegacyStaticExec) StaticExec {
+	return func(ctx context.Context, command *Command, cmd *cobra.Command, args []string) error {
+		return f(command, cmd, args)
+	}
+}
+
+// AdaptShellExec converts a LegacyShellExec to a ShellExec, the context is ignored
+func AdaptShellExec(f LegacyShellExec) ShellExec {
+	return func(ctx context.Context, command *Command, c *ishell.Context) error {
+		return f(command, c)
+	}
+}
+
+/*
+AdaptRequestHandler converts a LegacyRequestHandler to a RequestHandler, the
+handler is not interrupted by cancellation but its result is discarded and the
+context error returned when the context is done first
+*/
+func AdaptRequestHandler(f LegacyRequestHandler) RequestHandler {
+	return func(ctx context.Context, req, resp interface{}) error {
+		done := make(chan error, 1)
+		go func() {
+			done <- f(req, resp)
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AdaptCommandHook converts a LegacyCommandHook to a CommandHook, the context is ignored
+func AdaptCommandHook(f LegacyCommandHook) CommandHook {
+	return func(ctx context.Context, c *Command) error {
+		return f(c)
+	}
+}
 
-// ErrorHandler provides a handler fo all commander errors
+// ErrorHandler provides a handler for commander errors in static mode
 type ErrorHandler func(err error)
 
-// Command represents an OMP operation or other cli call
+// ShellErrorHandler provides a handler for commander errors in shell mode, the shell continues once it returns
+type ShellErrorHandler func(c *ishell.Context, err error)
+
+/*
+Command represents an OMP operation or other cli call. Request and Response are
+prototypes, each invocation runs on a copy of the command with its own request
+and response values (see NewInvocation) so commands can run concurrently
+*/
 type Command struct {
 	Commander       *Commander
 	Name            string
@@ -46,6 +129,28 @@ type Command struct {
 	Request         interface{}
 	Response        interface{}
 	RegisterFunc    RegisterFunc
+
+	// Template is the default text/template used to render the response (-o template)
+	Template string
+
+	// Timeout limits the request, the commander default is used when zero
+	Timeout time.Duration
+
+	// Middleware wraps the request handler for this command only, inside any commander middleware
+	Middleware []Middleware
+
+	// StatusCheck checks the response for error statuses, the commander status check is used when nil
+	StatusCheck StatusCheck
+
+	// Retry overrides the policy of any Retry middleware for this command, NoRetry disables retries
+	Retry *RetryPolicy
+
+	// HTTPMethod and HTTPPath declare the endpoint used by HTTPTransport, HTTPPath may hold {name} placeholders
+	HTTPMethod string
+	HTTPPath   string
+
+	// bound is the request instance static flags are bound to, copied for each static invocation
+	bound interface{}
 }
 
 // Register is called by the Command Register to handle the specifics of command registration
@@ -59,13 +164,13 @@ func (c *Command) Register(rootCmd *cobra.Command) error {
 
 		err := globalRegistrationHandler(rootCmd, c)
 		if err != nil {
-			return fmt.Errorf("error from commander registration handler: %s", err)
+			return fmt.Errorf("error from commander registration handler: %w", err)
 		}
 
 	} else {
 		err := c.RegisterFunc(rootCmd, c)
 		if err != nil {
-			return fmt.Errorf("error from command registration handler: %s", err)
+			return fmt.Errorf("error from command registration handler: %w", err)
 		}
 	}
 	return nil
@@ -90,91 +195,186 @@ func (c *Command) RegisterToShell(shell *ishell.Shell) {
 	})
 }
 
-// HandleRequest calls the appropriate request handler for the command, local preferred
-func (c *Command) HandleRequest(req, resp interface{}) error {
+/*
+HandleRequest calls the appropriate request handler for the command, local
+preferred, wrapped by the commander then command middleware. Error statuses
+reported in the response body are returned as errors (see StatusCheck). The
+request is limited by the timeout set with --timeout, the command Timeout or
+the commander default timeout (in that order), a *TimeoutError wrapping the
+handler error is returned when it is exceeded
+*/
+func (c *Command) HandleRequest(ctx context.Context, req, resp interface{}) error {
+
+	ctx = withCommand(ctx, c)
 
-	if c.RequestHandler == nil {
-		globalRequestHandler := c.Commander.DefaultRequestHandler()
-		if globalRequestHandler == nil {
+	timeout := c.timeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	handler, source := c.RequestHandler, "command"
+	if handler == nil {
+		handler, source = c.Commander.DefaultRequestHandler(), "commander"
+		if handler == nil {
 			return errors.New("no request handler defined")
 		}
+	}
 
-		err := globalRequestHandler(req, resp)
-		if err != nil {
-			return fmt.Errorf("error from commander request handler: %s", err)
-		}
+	// commander middleware wraps command middleware, which wraps the handler and status check
+	handler = c.withStatusCheck(handler)
+	handler = Chain(handler, c.Middleware...)
+	handler = Chain(handler, c.Commander.Middleware()...)
 
-	} else {
-		err := c.RequestHandler(req, resp)
-		if err != nil {
-			return fmt.Errorf("error from command request handler: %s", err)
-		}
+	err := handler(ctx, req, resp)
+	if err != nil {
+		err = fmt.Errorf("error from %s request handler: %w", source, err)
 	}
 
-	return nil
+	if err != nil && timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Command: c.Name, Duration: timeout, Err: err}
+	}
+
+	return err
+}
+
+// timeout returns the timeout applying to the command, zero for none
+func (c *Command) timeout() time.Duration {
+	if timeout := c.Commander.TimeoutOverride(); timeout > 0 {
+		return timeout
+	}
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+
+	return c.Commander.DefaultTimeout()
 }
 
-// HandleResponse calls the appropriate response handler for the command, local preferred
-func (c *Command) HandleResponse(resp interface{}) error {
+/*
+HandleResponse applies any commander query to the response, then calls the
+appropriate response handler for the command, local preferred, falling back to
+the commander output formatters
+*/
+func (c *Command) HandleResponse(out *Output, resp interface{}) error {
+
+	// project the response before any handler sees it
+	resp, err := c.Commander.ApplyQuery(resp)
+	if err != nil {
+		return fmt.Errorf("error applying query: %w", err)
+	}
 
 	if c.ResponseHandler == nil {
 		globalResponseHandler := c.Commander.DefaultResponseHandler()
 		if globalResponseHandler == nil {
-			return errors.New("no response handler defined")
+			// no handlers defined, render with the selected output format
+			return c.Commander.FormatResponse(c, out, resp)
 		}
-		err := globalResponseHandler(resp)
+		err := globalResponseHandler(out, resp)
 		if err != nil {
-			return fmt.Errorf("error from commander response handler: %s", err)
+			return fmt.Errorf("error from commander response handler: %w", err)
 		}
 
 	} else {
-		err := c.ResponseHandler(resp)
+		err := c.ResponseHandler(out, resp)
 		if err != nil {
-			return fmt.Errorf("error from command response handler: %s", err)
+			return fmt.Errorf("error from command response handler: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (c *Command) handleStatic(cmd *cobra.Command, args []string) {
+// WriteResponse handles the response, writing to the base output or the output file set with --out
+func (c *Command) WriteResponse(base *Output, resp interface{}) error {
+	out, closeOut, err := c.Commander.OpenOutput(base)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	return c.HandleResponse(out, resp)
+}
+
+/*
+NewInvocation returns a copy of the command with fresh Request and Response
+values of the same types, zeroed, the prototype command is left untouched so
+invocations can run concurrently
+*/
+func (c *Command) NewInvocation() (*Command, error) {
+	return c.invocation(nil)
+}
 
-	// run preHooks
-	preHooks := c.Commander.PreRequestHooks()
-	for _, preHook := range preHooks {
-		err := preHook(c)
+/*
+invocation copies the command, the request is a copy of src (zeroed when src is
+nil) and the response is zeroed, nil prototypes stay nil
+*/
+func (c *Command) invocation(src interface{}) (*Command, error) {
+	inv := *c
+
+	var err error
+	if src != nil {
+		inv.Request, err = cloneStruct(src)
+	} else if c.Request != nil {
+		inv.Request, err = c.resetStruct(c.Request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Response != nil {
+		inv.Response, err = c.resetStruct(c.Response)
 		if err != nil {
-			c.Commander.HandleError(err)
+			return nil, err
 		}
 	}
 
-	// run static exec function, fallback to global if not defined on command
-	if c.StaticExec == nil {
-		globalStaticExec := c.Commander.StaticExec()
-		if globalStaticExec == nil {
-			c.Commander.HandleError(errors.New("no static exec handler defined"))
-		} else {
-			err := globalStaticExec(c, cmd, args)
-			if err != nil {
-				c.Commander.HandleError(fmt.Errorf("error from commander static exec: %s", err))
+	return &inv, nil
+}
+
+/*
+handleStatic runs a static invocation, the request is a copy of the instance
+flags were bound to (or of the prototype request when bound by a custom
+RegisterFunc)
+*/
+func (c *Command) handleStatic(cmd *cobra.Command, args []string) {
+	src := c.bound
+	if src == nil {
+		src = c.Request
+	}
+
+	inv, err := c.invocation(src)
+	if err != nil {
+		c.Commander.handleStaticError(err)
+		return
+	}
+
+	inv.execStatic(cmd, args)
+}
+
+func (c *Command) execStatic(cmd *cobra.Command, args []string) {
+	c.run(ModeStatic, args, StdoutOutput(), c.Commander.handleStaticError, func(ctx context.Context) error {
+
+		// run static exec function, fallback to global if not defined on command
+		if c.StaticExec == nil {
+			globalStaticExec := c.Commander.StaticExec()
+			if globalStaticExec == nil {
+				return errors.New("no static exec handler defined")
 			}
 
+			err := globalStaticExec(ctx, c, cmd, args)
+			if err != nil {
+				return fmt.Errorf("error from commander static exec: %w", err)
+			}
+			return nil
 		}
-	} else {
-		err := c.StaticExec(c, cmd, args)
-		if err != nil {
-			c.Commander.HandleError(fmt.Errorf("error from command static exec: %s", err))
-		}
-	}
 
-	// run postHooks
-	postHooks := c.Commander.PostRequestHooks()
-	for _, postHook := range postHooks {
-		err := postHook(c)
+		err := c.StaticExec(ctx, c, cmd, args)
 		if err != nil {
-			c.Commander.HandleError(err)
+			return fmt.Errorf("error from command static exec: %w", err)
 		}
-	}
+		return nil
+	})
 }
 
 func (c *Command) resetStruct(structPtr interface{}) (interface{}, error) {
@@ -194,51 +394,156 @@ func (c *Command) resetStruct(structPtr interface{}) (interface{}, error) {
 	return reflect.New(val.Type()).Interface(), nil
 }
 
-func (c *Command) handleShell(sc *ishell.Context) {
-	var err error
-	c.Request, err = c.resetStruct(c.Request)
-	if err != nil {
-		c.Commander.HandleError(err)
+// cloneStruct returns a pointer to a shallow copy of the struct structPtr points to
+func cloneStruct(structPtr interface{}) (interface{}, error) {
+	ptrVal := reflect.ValueOf(structPtr)
+	if ptrVal.Kind() != reflect.Ptr || ptrVal.Elem().Kind() != reflect.Struct {
+		return nil, ErrStructPtrExpected
 	}
-	c.Response, err = c.resetStruct(c.Response)
+
+	res := reflect.New(ptrVal.Elem().Type())
+	res.Elem().Set(ptrVal.Elem())
+
+	return res.Interface(), nil
+}
+
+// handleShell runs a shell invocation with a fresh request and response
+func (c *Command) handleShell(sc *ishell.Context) {
+	inv, err := c.NewInvocation()
 	if err != nil {
-		c.Commander.HandleError(err)
+		c.Commander.HandleShellError(sc, err)
+		return
 	}
 
-	// run preHooks
-	preHooks := c.Commander.PreRequestHooks()
-	for _, preHook := range preHooks {
-		err := preHook(c)
-		if err != nil {
-			c.Commander.HandleError(err)
-		}
+	inv.execShell(sc)
+}
+
+func (c *Command) execShell(sc *ishell.Context) {
+	handleError := func(err error) {
+		c.Commander.HandleShellError(sc, err)
 	}
 
-	// run static exec function, fallback to global if not defined on command
-	if c.ShellExec == nil {
-		globalShellExec := c.Commander.ShellExec()
-		if globalShellExec == nil {
-			c.Commander.HandleError(errors.New("no shell exec handler defined"))
-		} else {
-			err := globalShellExec(c, sc)
-			if err != nil {
-				c.Commander.HandleError(fmt.Errorf("error from commander shell exec: %s", err))
+	c.run(ModeShell, sc.Args, ShellOutput(sc), handleError, func(ctx context.Context) error {
+
+		// run shell exec function, fallback to global if not defined on command
+		if c.ShellExec == nil {
+			globalShellExec := c.Commander.ShellExec()
+			if globalShellExec == nil {
+				return errors.New("no shell exec handler defined")
 			}
 
+			err := globalShellExec(ctx, c, sc)
+			if err != nil {
+				return fmt.Errorf("error from commander shell exec: %w", err)
+			}
+			return nil
 		}
-	} else {
-		err := c.ShellExec(c, sc)
+
+		err := c.ShellExec(ctx, c, sc)
 		if err != nil {
-			c.Commander.HandleError(fmt.Errorf("error from command shell exec: %s", err))
+			return fmt.Errorf("error from command shell exec: %w", err)
+		}
+		return nil
+	})
+}
+
+/*
+run executes the command with the invocation context, cancelled on interrupt
+(in the shell this returns to the prompt rather than exiting). Pre hooks run
+first and may stop the command (see runPreHooks), then exec, then the post
+hooks and the error or success hooks with the Result. The execution error,
+with any hook errors alongside it, is passed to handleError (the error handler
+for the mode) once, after every hook has run
+*/
+func (c *Command) run(mode Mode, args []string, out *Output, handleError func(error), exec func(ctx context.Context) error) {
+	ctx, stop := interruptContext(c.Commander.Context())
+	defer stop()
+
+	result := &Result{Command: c, Mode: mode, Args: args, Start: time.Now()}
+	c.runPreHooks(ctx, out, result)
+	if !result.Skipped && !result.ShortCircuited && result.Err == nil {
+		result.Err = exec(ctx)
+	}
+	result.Duration = time.Since(result.Start)
+
+	// run postHooks, then the outcome specific hooks
+	hooks := append([]ResultHook{}, c.Commander.PostRequestHooks()...)
+	switch {
+	case result.Skipped:
+		// skipped commands have no outcome
+	case result.Err != nil:
+		hooks = append(hooks, c.Commander.OnErrorHooks()...)
+	default:
+		hooks = append(hooks, c.Commander.OnSuccessHooks()...)
+	}
+	hookErr := c.runResultHooks(ctx, result, hooks)
+
+	// the execution error is reported first, the hook errors alongside it
+	switch {
+	case result.Err != nil && hookErr != nil:
+		handleError(fmt.Errorf("%w (%s)", result.Err, hookErr))
+	case result.Err != nil:
+		handleError(result.Err)
+	case hookErr != nil:
+		handleError(hookErr)
+	}
+}
+
+/*
+runPreHooks runs the pre hooks in order until one returns an error, which stops
+the command: ErrSkip skips it, a *ShortCircuit presents its response in place
+of executing, and any other error aborts it with an *AbortError
+*/
+func (c *Command) runPreHooks(ctx context.Context, out *Output, result *Result) {
+	for _, preHook := range c.Commander.PreRequestHooks() {
+		err := preHook(ctx, c)
+		if err == nil {
+			continue
 		}
+
+		var short *ShortCircuit
+		var abort *AbortError
+		switch {
+		case errors.Is(err, ErrSkip):
+			result.Skipped = true
+		case errors.As(err, &short):
+			result.ShortCircuited = true
+			result.Err = c.WriteResponse(out, short.Response)
+		case errors.As(err, &abort):
+			if abort.Command == "" {
+				abort.Command = c.Name
+			}
+			result.Err = abort
+		default:
+			result.Err = &AbortError{Command: c.Name, Reason: err.Error(), Err: err}
+		}
+		return
 	}
+}
 
-	// run postHooks
-	postHooks := c.Commander.PostRequestHooks()
-	for _, postHook := range postHooks {
-		err := postHook(c)
+/*
+runResultHooks calls every hook with the result, the hook errors are collected
+and returned as one error wrapping the first
+*/
+func (c *Command) runResultHooks(ctx context.Context, result *Result, hooks []ResultHook) error {
+	var first error
+	msgs := []string{}
+	for _, hook := range hooks {
+		err := hook(ctx, result)
 		if err != nil {
-			c.Commander.HandleError(err)
+			if first == nil {
+				first = err
+			}
+			msgs = append(msgs, err.Error())
 		}
 	}
+
+	switch len(msgs) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("hook error: %w", first)
+	default:
+		return fmt.Errorf("hook errors: %w; %s", first, strings.Join(msgs[1:], "; "))
+	}
 }
@@ -1,10 +1,15 @@
 package combi
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/abiosoft/ishell.v2"
@@ -14,42 +19,288 @@ import (
 type Commander struct {
 	commands map[string]*Command
 	sync.RWMutex
+
+	// registry serialises changes to the commands, cli and shells
+	registry            sync.Mutex
+	shells              []*ishell.Shell
+	listeners           []RegistryListener
+	lazy                bool
+	shellConfig         ShellConfig
+	scripting           bool
+	batch               *scriptBatch
+	usageChecked        map[*cobra.Command]bool
+	executing           bool
+	execErr             error
+	ctx                 context.Context
 	rootCmd             *cobra.Command
 	preRequest          []CommandHook
-	postRequest         []CommandHook
+	postRequest         []ResultHook
+	onError             []ResultHook
+	onSuccess           []ResultHook
+	retryHooks          []RetryHook
+	middleware          []Middleware
 	requestHandler      RequestHandler
 	responseHandler     ResponseHandler
 	registrationHandler RegisterFunc
 	errorHandler        ErrorHandler
+	shellErrorHandler   ShellErrorHandler
+	exitCodes           []exitCode
+	statusCheck         StatusCheck
 	staticExec          StaticExec
 	shellExec           ShellExec
+	formatters          map[string]Formatter
+	formatterFactories  map[string]FormatterFactory
+	outputFormat        string
+	outputSelected      bool
+	query               *Query
+	outputFile          string
+	tee                 bool
+	table               Table
+	timeout             time.Duration
+	timeoutOverride     time.Duration
+	sessions            *Sessions
+	codecs              map[string]Codec
+	codec               string
+	requestFile         string
 }
 
 // NewCommander returns an instantiated Commander object to contain managed commands
 func NewCommander(rootCommand *cobra.Command) *Commander {
-	return &Commander{
+	c := &Commander{
 		commands:            map[string]*Command{},
+		ctx:                 context.Background(),
 		rootCmd:             rootCommand,
-		errorHandler:        DefaultErrorHandler,
-		responseHandler:     XMLPrettyPrintResponseHandler,
+		shellErrorHandler:   DefaultShellErrorHandler,
 		staticExec:          GenericStaticHandler,
 		shellExec:           GenericShellHandler,
 		registrationHandler: DefaultRegistrationHandler,
+		formatters:          defaultFormatters(),
+		formatterFactories:  defaultFormatterFactories(),
+		outputFormat:        FormatXML,
+		sessions:            NewSessions(),
+		codecs:              defaultCodecs(),
+		codec:               CodecXML,
+		exitCodes:           defaultExitCodes(),
+		statusCheck:         CheckStatus,
+	}
+
+	// static errors exit with the code mapped to their class
+	c.errorHandler = func(err error) {
+		printStaticError(err)
+		os.Exit(c.ExitCode(err))
+	}
+
+	// table output honours the commander table settings (--columns, --sort-by, --no-headers)
+	c.formatters[FormatTable] = func(w io.Writer, resp interface{}) error {
+		return c.TableOptions().Format(w, resp)
 	}
+	c.formatters[FormatCSV] = func(w io.Writer, resp interface{}) error {
+		return c.TableOptions().FormatCSV(w, resp)
+	}
+	c.bindSettings()
+
+	return c
 }
 
-// HandleError will call the errorHandler for any commander errors
+// HandleError will call the errorHandler for commander errors in static mode
 func (c *Commander) HandleError(err error) {
-	c.errorHandler(err)
+	c.RLock()
+	eh := c.errorHandler
+	c.RUnlock()
+	eh(err)
 }
 
-// SetErrorHandler sets the error handler for all commander errors
+// SetErrorHandler sets the error handler for commander errors in static mode
 func (c *Commander) SetErrorHandler(eh ErrorHandler) {
 	c.Lock()
 	defer c.Unlock()
 	c.errorHandler = eh
 }
 
+/*
+SetExitCode sets the process exit code used by the default static error handler
+for errors matching class with errors.Is, e.g. SetExitCode(ErrAuth, 10). Classes
+are checked in the order first set, the defaults being context.Canceled 130,
+ErrUsage 2, ErrValidation 3, ErrAuth 4, ErrTimeout 5, ErrTransport 6 and
+ErrServer 7
+*/
+func (c *Commander) SetExitCode(class error, code int) {
+	c.Lock()
+	defer c.Unlock()
+	for i := range c.exitCodes {
+		if c.exitCodes[i].class == class {
+			c.exitCodes[i].code = code
+			return
+		}
+	}
+	c.exitCodes = append(c.exitCodes, exitCode{class, code})
+}
+
+// ExitCode returns the process exit code for err, 0 for nil and 1 when no class matches
+func (c *Commander) ExitCode(err error) int {
+	c.RLock()
+	defer c.RUnlock()
+	return exitCodeFor(c.exitCodes, err)
+}
+
+// StatusCheck returns the status check applied to responses of commands without their own
+func (c *Commander) StatusCheck() StatusCheck {
+	c.RLock()
+	defer c.RUnlock()
+	return c.statusCheck
+}
+
+// SetStatusCheck sets the status check applied to responses of commands without their own, nil disables checks
+func (c *Commander) SetStatusCheck(sc StatusCheck) {
+	c.Lock()
+	defer c.Unlock()
+	c.statusCheck = sc
+}
+
+/*
+HandleShellError will call the shell error handler for commander errors in
+shell mode. When running a script the error is instead recorded on the context
+with sc.Err, failing the script command
+*/
+func (c *Commander) HandleShellError(sc *ishell.Context, err error) {
+	c.RLock()
+	eh, scripting := c.shellErrorHandler, c.scripting
+	c.RUnlock()
+	if scripting {
+		sc.Err(err)
+		return
+	}
+	eh(sc, err)
+}
+
+// SetShellErrorHandler sets the error handler for commander errors in shell mode
+func (c *Commander) SetShellErrorHandler(eh ShellErrorHandler) {
+	c.Lock()
+	defer c.Unlock()
+	c.shellErrorHandler = eh
+}
+
+// Context returns the base context all command invocations are derived from
+func (c *Commander) Context() context.Context {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ctx
+}
+
+// SetContext sets the base context all command invocations are derived from
+func (c *Commander) SetContext(ctx context.Context) {
+	c.Lock()
+	defer c.Unlock()
+	c.ctx = ctx
+}
+
+// DefaultTimeout returns the timeout applied to commands which do not set their own
+func (c *Commander) DefaultTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.timeout
+}
+
+// SetDefaultTimeout sets the timeout applied to commands which do not set their own, zero for none
+func (c *Commander) SetDefaultTimeout(timeout time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.timeout = timeout
+}
+
+// TimeoutOverride returns the timeout set with --timeout, applied to all commands
+func (c *Commander) TimeoutOverride() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.timeoutOverride
+}
+
+/*
+SetTimeoutOverride sets a timeout applied to all commands, taking precedence
+over command and default timeouts, zero to clear
+*/
+func (c *Commander) SetTimeoutOverride(timeout time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.timeoutOverride = timeout
+}
+
+/*
+RegisterCodec adds or replaces a named codec, it is also registered as the
+output format of the same name (see CodecFormatter) unless one exists
+*/
+func (c *Commander) RegisterCodec(name string, codec Codec) {
+	c.Lock()
+	defer c.Unlock()
+	c.codecs[name] = codec
+	if _, ok := c.formatters[name]; !ok {
+		c.formatters[name] = CodecFormatter(codec)
+	}
+}
+
+// Codec returns the codec registered under name
+func (c *Commander) Codec(name string) (Codec, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if codec, ok := c.codecs[name]; ok {
+		return codec, nil
+	}
+
+	return nil, classify(ErrUsage, fmt.Errorf("unknown codec: %s", name))
+}
+
+// CodecName returns the name of the selected codec
+func (c *Commander) CodecName() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.codec
+}
+
+// DefaultCodec returns the selected codec, used by transports and request files
+func (c *Commander) DefaultCodec() Codec {
+	c.RLock()
+	defer c.RUnlock()
+	return c.codecs[c.codec]
+}
+
+// SetCodec selects the codec used by transports and request files
+func (c *Commander) SetCodec(name string) error {
+	_, err := c.Codec(name)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.codec = name
+	return nil
+}
+
+// RequestFile returns the path requests are loaded from, empty for none
+func (c *Commander) RequestFile() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.requestFile
+}
+
+// SetRequestFile sets a file requests are loaded from before flags / prompts are applied, empty for none
+func (c *Commander) SetRequestFile(path string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.requestFile = path
+	return nil
+}
+
+// Sessions returns the session manager used by transports to reuse connections between commands
+func (c *Commander) Sessions() *Sessions {
+	return c.sessions
+}
+
+// Close closes any open sessions, it should be called before the process exits
+func (c *Commander) Close() error {
+	return c.sessions.Close()
+}
+
 // RegistrationHandler will return the registered global register function
 func (c *Commander) RegistrationHandler() RegisterFunc {
 	c.RLock()
@@ -126,6 +377,205 @@ func (c *Commander) SetDefaultResponseHandler(rh ResponseHandler) {
 	c.responseHandler = rh
 }
 
+// RegisterFormatter adds or replaces a named output formatter
+func (c *Commander) RegisterFormatter(name string, f Formatter) {
+	c.Lock()
+	defer c.Unlock()
+	c.formatters[name] = f
+}
+
+// RegisterFormatterFactory adds or replaces a named parameterised output formatter (-o name=arg)
+func (c *Commander) RegisterFormatterFactory(name string, f FormatterFactory) {
+	c.Lock()
+	defer c.Unlock()
+	c.formatterFactories[name] = f
+}
+
+// Formatter returns the output formatter registered under name
+func (c *Commander) Formatter(name string) (Formatter, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if f, ok := c.formatters[name]; ok {
+		return f, nil
+	}
+
+	return nil, classify(ErrUsage, fmt.Errorf("unknown output format: %s", name))
+}
+
+/*
+FormatterFor resolves an output format spec for the given command, specs take
+the form name or name=arg, arguments are passed to the formatter factory
+registered under name
+*/
+func (c *Commander) FormatterFor(cmd *Command, spec string) (Formatter, error) {
+	name, arg := spec, ""
+	hasArg := false
+	if i := strings.Index(spec, "="); i >= 0 {
+		name, arg, hasArg = spec[:i], spec[i+1:], true
+	}
+
+	c.RLock()
+	factory, isFactory := c.formatterFactories[name]
+	c.RUnlock()
+
+	if isFactory {
+		return factory(cmd, arg)
+	}
+	if hasArg {
+		return nil, classify(ErrUsage, fmt.Errorf("output format %s does not take an argument", name))
+	}
+
+	return c.Formatter(name)
+}
+
+// Formats returns the names of all registered output formatters, sorted
+func (c *Commander) Formats() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := []string{}
+	for name := range c.formatters {
+		names = append(names, name)
+	}
+	for name := range c.formatterFactories {
+		names = append(names, name+"=...")
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// OutputFormat returns the selected output format spec
+func (c *Commander) OutputFormat() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.outputFormat
+}
+
+// SetOutputFormat selects the output format spec (name or name=arg) used by FormatResponse
+func (c *Commander) SetOutputFormat(spec string) error {
+	_, err := c.FormatterFor(nil, spec)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.outputFormat = spec
+	c.outputSelected = true
+	return nil
+}
+
+// Query returns the query expression applied to responses, empty when none is set
+func (c *Commander) Query() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.query == nil {
+		return ""
+	}
+	return c.query.String()
+}
+
+/*
+SetQuery sets the query expression applied to all responses before they are
+handled, an empty expression clears the query
+*/
+func (c *Commander) SetQuery(expr string) error {
+	var query *Query
+	if expr != "" {
+		var err error
+		query, err = CompileQuery(expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.query = query
+	return nil
+}
+
+// ApplyQuery applies the query set with SetQuery (or --query) to the response
+func (c *Commander) ApplyQuery(resp interface{}) (interface{}, error) {
+	c.RLock()
+	query := c.query
+	c.RUnlock()
+
+	if query == nil {
+		return resp, nil
+	}
+
+	return query.Apply(resp)
+}
+
+/*
+FormatResponse renders the response to the output using the output format selected
+with SetOutputFormat, the --output flag or the shell "set output" setting. When
+no format has been selected and the command declares a Template, the template
+is used instead
+*/
+func (c *Commander) FormatResponse(cmd *Command, out *Output, resp interface{}) error {
+	c.RLock()
+	spec, selected := c.outputFormat, c.outputSelected
+	c.RUnlock()
+
+	if !selected && cmd != nil && cmd.Template != "" {
+		spec = FormatTemplate
+	}
+
+	f, err := c.FormatterFor(cmd, spec)
+	if err != nil {
+		return err
+	}
+
+	return f(out, resp)
+}
+
+// OutputFile returns the path responses are written to, empty for the terminal
+func (c *Commander) OutputFile() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.outputFile
+}
+
+// SetOutputFile sets a file path responses are written to, empty to write to the terminal
+func (c *Commander) SetOutputFile(path string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.outputFile = path
+	return nil
+}
+
+// Tee returns whether responses written to the output file are also written to the terminal
+func (c *Commander) Tee() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.tee
+}
+
+// TableOptions returns a copy of the options used by the table and csv output formats
+func (c *Commander) TableOptions() Table {
+	c.RLock()
+	defer c.RUnlock()
+	t := c.table
+	t.Columns = append([]string{}, c.table.Columns...)
+	return t
+}
+
+// SetTableOptions sets the options used by the table and csv output formats
+func (c *Commander) SetTableOptions(t Table) {
+	c.Lock()
+	defer c.Unlock()
+	c.table = t
+}
+
+// SetTee sets whether responses written to the output file are also written to the terminal
+func (c *Commander) SetTee(tee bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.tee = tee
+}
+
 // AddPreRequestHooks provides for command decorators to be run before all requests are handled
 func (c *Commander) AddPreRequestHooks(hooks ...CommandHook) {
 	c.Lock()
@@ -142,8 +592,43 @@ func (c *Commander) PreRequestHooks() []CommandHook {
 	return c.preRequest
 }
 
-// AddPostRequestHooks provides for command decorators to be run after all requests are handled
-func (c *Commander) AddPostRequestHooks(hooks ...CommandHook) {
+/*
+Use adds middleware wrapping the request handler of every command, middleware
+runs in the order added, outside of any command middleware
+*/
+func (c *Commander) Use(middleware ...Middleware) {
+	c.Lock()
+	defer c.Unlock()
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// Middleware returns the middleware added with Use
+func (c *Commander) Middleware() []Middleware {
+	c.RLock()
+	defer c.RUnlock()
+	return c.middleware
+}
+
+// AddRetryHooks adds hooks called by the Retry middleware after each failed attempt
+func (c *Commander) AddRetryHooks(hooks ...RetryHook) {
+	c.Lock()
+	defer c.Unlock()
+	c.retryHooks = append(c.retryHooks, hooks...)
+}
+
+// RetryHooks returns the registered retry hooks
+func (c *Commander) RetryHooks() []RetryHook {
+	c.RLock()
+	defer c.RUnlock()
+	return c.retryHooks
+}
+
+/*
+AddPostRequestHooks provides for hooks to be run after all commands have
+executed, successfully or not, with the Result of the execution. CommandHooks
+can be converted with AdaptPostHook
+*/
+func (c *Commander) AddPostRequestHooks(hooks ...ResultHook) {
 	c.Lock()
 	defer c.Unlock()
 	for _, hook := range hooks {
@@ -152,12 +637,40 @@ func (c *Commander) AddPostRequestHooks(hooks ...CommandHook) {
 }
 
 // PostRequestHooks returns the refistered PostRequestHooks
-func (c *Commander) PostRequestHooks() []CommandHook {
+func (c *Commander) PostRequestHooks() []ResultHook {
 	c.RLock()
 	defer c.RUnlock()
 	return c.postRequest
 }
 
+// AddOnErrorHooks provides for hooks to be run after post hooks when a command fails
+func (c *Commander) AddOnErrorHooks(hooks ...ResultHook) {
+	c.Lock()
+	defer c.Unlock()
+	c.onError = append(c.onError, hooks...)
+}
+
+// OnErrorHooks returns the registered OnError hooks
+func (c *Commander) OnErrorHooks() []ResultHook {
+	c.RLock()
+	defer c.RUnlock()
+	return c.onError
+}
+
+// AddOnSuccessHooks provides for hooks to be run after post hooks when a command succeeds
+func (c *Commander) AddOnSuccessHooks(hooks ...ResultHook) {
+	c.Lock()
+	defer c.Unlock()
+	c.onSuccess = append(c.onSuccess, hooks...)
+}
+
+// OnSuccessHooks returns the registered OnSuccess hooks
+func (c *Commander) OnSuccessHooks() []ResultHook {
+	c.RLock()
+	defer c.RUnlock()
+	return c.onSuccess
+}
+
 // FormatPrinter provides a simple interface for anything that implements Printf
 type FormatPrinter interface {
 	Printf(format string, a ...interface{})
@@ -192,6 +705,8 @@ func (c *Commander) PrintCommandList(f FormatPrinter) {
 
 // Cmd retrieves a command by name from the register
 func (c *Commander) Cmd(name string) (*Command, error) {
+	c.RLock()
+	defer c.RUnlock()
 	if cmd, ok := c.commands[name]; ok {
 		return cmd, nil
 	}
@@ -199,15 +714,14 @@ func (c *Commander) Cmd(name string) (*Command, error) {
 	return nil, errors.New("failed to resolve command in register")
 }
 
-// Add will add one or more commands to the command register
+/*
+Add will add one or more commands to the command register, along with the cli
+and any registered shells, names must be unique (see Replace)
+*/
 func (c *Commander) Add(cmds ...*Command) error {
 
 	for _, cmd := range cmds {
-		cmd.Commander = c
-		c.Lock()
-		c.commands[cmd.Name] = cmd
-		c.Unlock()
-		err := cmd.Register(c.rootCmd)
+		err := c.add(cmd)
 		if err != nil {
 			return err
 		}
@@ -217,22 +731,52 @@ func (c *Commander) Add(cmds ...*Command) error {
 }
 
 /*
-RegisterShell will register commands with the provided shell, this should be
-called after all commands have been added to the commander
+RegisterShell will register commands with the provided shell, the shell is
+tracked so commands added, removed or replaced later are updated in it too
 */
 func (c *Commander) RegisterShell(shell *ishell.Shell) error {
+	c.registry.Lock()
+	defer c.registry.Unlock()
 	c.Lock()
 	defer c.Unlock()
+	for _, tracked := range c.shells {
+		if tracked == shell {
+			return nil
+		}
+	}
+	c.shells = append(c.shells, shell)
+
 	for _, command := range c.commands {
 		command.RegisterToShell(shell)
 	}
+	if c.rootCmd != nil {
+		shell.AddCmd(c.shellSetCmd())
+	}
+
+	// replace the default exit command to close sessions cleanly
+	shell.AddCmd(&ishell.Cmd{
+		Name: "exit",
+		Help: "exit the program",
+		Func: func(sc *ishell.Context) {
+			err := c.Close()
+			if err != nil {
+				c.HandleShellError(sc, err)
+			}
+			sc.Stop()
+		},
+	})
 	return nil
 }
 
-// All will return a map of all registered commands
+// All will return a snapshot map of all registered commands, later registry changes are not reflected in it
 func (c *Commander) All() map[string]*Command {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
+
+	all := make(map[string]*Command, len(c.commands))
+	for name, cmd := range c.commands {
+		all[name] = cmd
+	}
 
-	return c.commands
+	return all
 }
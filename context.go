@@ -0,0 +1,72 @@
+package combi
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// commandKey is the context key holding the executing command
+type commandKey struct{}
+
+// withCommand returns a context carrying the command
+func withCommand(ctx context.Context, c *Command) context.Context {
+	return context.WithValue(ctx, commandKey{}, c)
+}
+
+/*
+CommandFromContext returns the command being executed, available to request
+handlers, transports and hooks called with the context passed to HandleRequest
+*/
+func CommandFromContext(ctx context.Context) (*Command, bool) {
+	c, ok := ctx.Value(commandKey{}).(*Command)
+	return c, ok
+}
+
+/*
+interruptContext returns a context cancelled when the process receives an
+interrupt (Ctrl-C), while it is active the first interrupt no longer terminates
+the process. A second interrupt restores the default handling and is raised
+again, so handlers ignoring the context can still be stopped. stop must be
+called to release the signal handler
+*/
+func interruptContext(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			raiseInterrupt()
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel()
+	}
+}
+
+// raiseInterrupt sends an interrupt to the process, exiting with 130 where signals can't be sent
+func raiseInterrupt() {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(os.Interrupt)
+	}
+	if err != nil {
+		os.Exit(130)
+	}
+}
@@ -0,0 +1,60 @@
+package combi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestInterruptContext(t *testing.T) {
+	if os.Getenv("COMBI_INTERRUPT_CHILD") == "1" {
+		ctx, stop := interruptContext(context.Background())
+		defer stop()
+
+		fmt.Println("ready")
+		<-ctx.Done()
+		fmt.Println("cancelled")
+
+		// a handler ignoring the context
+		time.Sleep(10 * time.Second)
+		fmt.Println("survived")
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent on windows")
+	}
+
+	child := exec.Command(os.Args[0], "-test.run=^TestInterruptContext$")
+	child.Env = append(os.Environ(), "COMBI_INTERRUPT_CHILD=1")
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(stdout)
+
+	// the first interrupt cancels the context, the second terminates the process
+	for _, want := range []string{"ready", "cancelled"} {
+		if !lines.Scan() || lines.Text() != want {
+			child.Process.Kill()
+			t.Fatalf("child printed %q, want %q", lines.Text(), want)
+		}
+		child.Process.Signal(os.Interrupt)
+	}
+
+	for lines.Scan() {
+		if lines.Text() == "survived" {
+			t.Error("child survived a second interrupt")
+		}
+	}
+	if err := child.Wait(); err == nil {
+		t.Error("child exited successfully, want it interrupted")
+	}
+}
@@ -0,0 +1,224 @@
+package combi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSkip can be returned by a pre hook to skip the command without error
+var ErrSkip = errors.New("command skipped")
+
+/*
+Error classes, errors returned by combi match one of these with errors.Is
+(alongside their own type and cause) so callers, and the exit code mapping,
+can branch on the kind of failure
+*/
+var (
+	ErrValidation = errors.New("validation failed")
+	ErrTransport  = errors.New("transport failure")
+	ErrTimeout    = errors.New("request timed out")
+	ErrAuth       = errors.New("authentication failed")
+	ErrServer     = errors.New("server reported an error")
+	ErrUsage      = errors.New("invalid usage")
+)
+
+// classError adds an error class to err without changing its message
+type classError struct {
+	class error
+	err   error
+}
+
+// classify marks err as belonging to class, nil stays nil
+func classify(class error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classError{class: class, err: err}
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() error {
+	return e.err
+}
+
+// Is matches the error class
+func (e *classError) Is(target error) bool {
+	return target == e.class
+}
+
+/*
+AbortError is the execution error when a pre hook stops a command, pre hooks
+can return one with Abort, any other error returned by a pre hook is wrapped
+in an AbortError
+*/
+type AbortError struct {
+	Command string
+	Reason  string
+	Err     error
+}
+
+// Abort returns an error a pre hook can return to stop the command with a reason
+func Abort(reason string) error {
+	return &AbortError{Reason: reason}
+}
+
+func (e *AbortError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("aborted: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("%s aborted: %s", e.Command, e.Reason)
+}
+
+// Unwrap returns the pre hook error, if any
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+/*
+ShortCircuit can be returned by a pre hook to skip the request and present
+Response in its place, e.g. a response served from a cache
+*/
+type ShortCircuit struct {
+	Response interface{}
+}
+
+func (s *ShortCircuit) Error() string {
+	return "command short-circuited"
+}
+
+// TimeoutError is returned by HandleRequest when a request exceeds its timeout
+type TimeoutError struct {
+	Command  string
+	Duration time.Duration
+
+	// Err is the error the request handler returned once the timeout was exceeded
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Command, e.Duration)
+}
+
+// Timeout always reports true, matching the net.Error convention
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Unwrap returns the request handler error
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrTimeout and context.DeadlineExceeded, whatever the handler returned
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+/*
+StatusError is returned when a server reports an error status in its response,
+such as the status and status_text attributes of an OMP response
+*/
+type StatusError struct {
+	Code int
+	Text string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", e.Code, e.Text)
+}
+
+// Is matches ErrServer
+func (e *StatusError) Is(target error) bool {
+	return target == ErrServer
+}
+
+// HTTPError is returned by HTTPTransport when the server replies with a non 2xx status
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("server returned %s", e.Status)
+}
+
+// Is matches ErrAuth for 401 and 403 replies, ErrServer otherwise
+func (e *HTTPError) Is(target error) bool {
+	if e.StatusCode == 401 || e.StatusCode == 403 {
+		return target == ErrAuth
+	}
+
+	return target == ErrServer
+}
+
+// RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Is matches ErrServer
+func (e *RPCError) Is(target error) bool {
+	return target == ErrServer
+}
+
+// transportError classifies err as ErrTransport, unless the context is done as cancellation and timeouts have their own class
+func transportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+
+	return classify(ErrTransport, err)
+}
+
+// exitCode maps an error class to a process exit code
+type exitCode struct {
+	class error
+	code  int
+}
+
+/*
+defaultExitCodes returns the exit codes used by static commands, checked in
+order, errors matching none of them exit with 1. Cancellation is checked first,
+an interrupted request exits with 130 whatever else it failed with
+*/
+func defaultExitCodes() []exitCode {
+	return []exitCode{
+		{context.Canceled, 130},
+		{ErrUsage, 2},
+		{ErrValidation, 3},
+		{ErrAuth, 4},
+		{ErrTimeout, 5},
+		{ErrTransport, 6},
+		{ErrServer, 7},
+	}
+}
+
+// exitCodeFor returns the code of the first class err matches, 0 for nil and 1 for unclassified errors
+func exitCodeFor(codes []exitCode, err error) int {
+	if err == nil {
+		return 0
+	}
+
+	for _, ec := range codes {
+		if errors.Is(err, ec.class) {
+			return ec.code
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,78 @@
+package combi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("boom"), 1},
+		{classify(ErrUsage, errors.New("unknown flag")), 2},
+		{classify(ErrValidation, errors.New("name is required")), 3},
+		{&HTTPError{StatusCode: 401}, 4},
+		{&TimeoutError{Command: "get-tasks", Duration: time.Second}, 5},
+		{classify(ErrTransport, errors.New("connection refused")), 6},
+		{&StatusError{Code: 404}, 7},
+		{context.Canceled, 130},
+
+		// cancellation wins over the class of the failure it caused
+		{classify(ErrTransport, fmt.Errorf("unable to send request: %w", context.Canceled)), 130},
+	}
+
+	c := NewCommander(&cobra.Command{Use: "app"})
+	for _, test := range tests {
+		if got := c.ExitCode(test.err); got != test.want {
+			t.Errorf("%v: exit code %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestCancelledRequests(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	transports := map[string]RequestHandler{
+		"http":     (&HTTPTransport{BaseURL: srv.URL, Codec: JSONCodec{}}).RequestHandler(),
+		"json-rpc": (&JSONRPCTransport{URL: srv.URL}).RequestHandler(),
+	}
+	for name, handler := range transports {
+		c := NewCommander(&cobra.Command{Use: "app"})
+		cmd := &Command{
+			Name:           "get-task",
+			HTTPPath:       "/tasks",
+			Request:        &executeRequest{},
+			Response:       &executeResponse{},
+			RequestHandler: handler,
+		}
+		if err := c.Add(cmd); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		err := cmd.HandleRequest(ctx, &executeRequest{}, &executeResponse{})
+		if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTransport) {
+			t.Errorf("%s: error %v, want context.Canceled and not ErrTransport", name, err)
+		}
+		if code := c.ExitCode(err); code != 130 {
+			t.Errorf("%s: exit code %d, want 130", name, code)
+		}
+	}
+}
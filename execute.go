@@ -0,0 +1,257 @@
+package combi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/abiosoft/ishell.v2"
+)
+
+// shellCommandName is the static command launching the interactive shell
+const shellCommandName = "shell"
+
+// ShellConfig configures the interactive shell launched by Execute
+type ShellConfig struct {
+	// Prompt is shown before each command, "<root command name>> " when empty
+	Prompt string
+
+	// Banner is printed when the shell starts
+	Banner string
+
+	// HistoryPath is the file history is kept in, relative to the home directory unless absolute, no history when empty
+	HistoryPath string
+}
+
+// ShellConfig returns the configuration used for shells built by NewShell
+func (c *Commander) ShellConfig() ShellConfig {
+	c.RLock()
+	defer c.RUnlock()
+	return c.shellConfig
+}
+
+// SetShellConfig sets the configuration used for shells built by NewShell
+func (c *Commander) SetShellConfig(cfg ShellConfig) {
+	c.Lock()
+	defer c.Unlock()
+	c.shellConfig = cfg
+}
+
+/*
+NewShell builds an interactive shell from the shell config with all commands,
+settings and built ins registered (see RegisterShell)
+*/
+func (c *Commander) NewShell() (*ishell.Shell, error) {
+	cfg := c.ShellConfig()
+
+	shell := ishell.New()
+
+	prompt := cfg.Prompt
+	if prompt == "" && c.rootCmd != nil {
+		prompt = c.rootCmd.Name() + "> "
+	}
+	if prompt != "" {
+		shell.SetPrompt(prompt)
+	}
+
+	if cfg.HistoryPath != "" {
+		if filepath.IsAbs(cfg.HistoryPath) {
+			shell.SetHistoryPath(cfg.HistoryPath)
+		} else {
+			shell.SetHomeHistoryPath(cfg.HistoryPath)
+		}
+	}
+
+	err := c.RegisterShell(shell)
+	if err != nil {
+		return nil, err
+	}
+
+	return shell, nil
+}
+
+// RunShell runs an interactive shell until it exits, then closes any open sessions
+func (c *Commander) RunShell() error {
+	shell, err := c.NewShell()
+	if err != nil {
+		return err
+	}
+
+	if banner := c.ShellConfig().Banner; banner != "" {
+		shell.Println(banner)
+	}
+	shell.Run()
+	shell.Close()
+
+	return c.Close()
+}
+
+/*
+Execute is the entry point for applications, running with the process
+arguments (see ExecuteArgs)
+*/
+func (c *Commander) Execute() error {
+	return c.ExecuteArgs(os.Args[1:])
+}
+
+/*
+ExecuteArgs launches the interactive shell when no args are given, otherwise it
+runs the static cli, where the shell command also launches the shell (allowing
+global flags first, e.g. app -o json shell). When stdin is not a terminal, or
+with shell --script, shell commands are run as a script instead (see
+RunScript). Sessions are closed before it returns, command errors are then
+passed to the error handler and returned. Errors from the cli (unknown
+commands, invalid flags or args) match ErrUsage, errors returned by commands
+are passed through, so applications can finish with
+os.Exit(commander.ExitCode(err))
+*/
+func (c *Commander) ExecuteArgs(args []string) error {
+	if c.rootCmd == nil {
+		return errors.New("no root command defined")
+	}
+
+	if len(args) == 0 {
+		return c.runShellOrScript("", ScriptOptions{})
+	}
+
+	c.addShellCommand()
+	c.classifyUsageErrors()
+	c.rootCmd.SetArgs(args)
+
+	c.setExecuting(true)
+	err := c.rootCmd.Execute()
+	execErr := c.setExecuting(false)
+
+	closeErr := c.Close()
+	if err != nil {
+
+		// unknown commands are found without running anything
+		if _, _, findErr := c.rootCmd.Find(args); findErr != nil {
+			return classify(ErrUsage, err)
+		}
+		return err
+	}
+
+	// command errors are handled once sessions are closed, the default handler exits
+	if execErr != nil {
+		c.HandleError(execErr)
+		return execErr
+	}
+
+	return closeErr
+}
+
+/*
+setExecuting marks whether ExecuteArgs is running the cli, returning the first
+static command error recorded since it started
+*/
+func (c *Commander) setExecuting(executing bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	err := c.execErr
+	c.executing, c.execErr = executing, nil
+
+	return err
+}
+
+/*
+handleStaticError records the error of a static command run by ExecuteArgs, to
+be handled once the cli returns, otherwise it is passed to the error handler
+*/
+func (c *Commander) handleStaticError(err error) {
+	c.Lock()
+	if c.executing {
+		if c.execErr == nil {
+			c.execErr = err
+		}
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+
+	c.HandleError(err)
+}
+
+/*
+classifyUsageErrors makes the flag and args errors of the cli commands match
+ErrUsage, through the root flag error func and by wrapping the args validators
+*/
+func (c *Commander) classifyUsageErrors() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.usageChecked == nil {
+		c.usageChecked = map[*cobra.Command]bool{}
+
+		flagErrorFunc := c.rootCmd.FlagErrorFunc()
+		c.rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+			return classify(ErrUsage, flagErrorFunc(cmd, err))
+		})
+	}
+
+	cmds := []*cobra.Command{c.rootCmd}
+	for len(cmds) > 0 {
+		cmd := cmds[0]
+		cmds = append(cmds[1:], cmd.Commands()...)
+		if c.usageChecked[cmd] || cmd.Args == nil {
+			continue
+		}
+
+		validate := cmd.Args
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			return classify(ErrUsage, validate(cmd, args))
+		}
+		c.usageChecked[cmd] = true
+	}
+}
+
+/*
+runShellOrScript runs the script at path when given, or commands read from
+stdin when it is not a terminal, otherwise the interactive shell
+*/
+func (c *Commander) runShellOrScript(path string, opts ScriptOptions) error {
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return classify(ErrUsage, fmt.Errorf("unable to open script: %w", err))
+		}
+		defer f.Close()
+
+		return c.RunScript(f, opts)
+	}
+
+	if !isTerminal(os.Stdin) {
+		return c.RunScript(os.Stdin, opts)
+	}
+
+	return c.RunShell()
+}
+
+// addShellCommand adds the static shell command, unless a command of that name exists
+func (c *Commander) addShellCommand() {
+	c.registry.Lock()
+	defer c.registry.Unlock()
+
+	if len(c.staticCommands(shellCommandName)) > 0 {
+		return
+	}
+
+	var script string
+	var opts ScriptOptions
+	shellCmd := &cobra.Command{
+		Use:   shellCommandName,
+		Short: "launch the interactive shell, or run a script of shell commands",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := c.runShellOrScript(script, opts)
+			if err != nil {
+				c.handleStaticError(err)
+			}
+		},
+	}
+	shellCmd.Flags().StringVar(&script, "script", "", "run shell commands from a file, one per line")
+	shellCmd.Flags().BoolVar(&opts.ContinueOnError, "continue-on-error", false, "keep running script commands after a failure")
+	c.rootCmd.AddCommand(shellCmd)
+}
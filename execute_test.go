@@ -0,0 +1,114 @@
+package combi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type executeRequest struct {
+	Name string `lFlag:"name" hint:"task name"`
+}
+
+type executeResponse struct {
+	Name string `xml:"name"`
+}
+
+// closeRecorder records whether the session was closed
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// newExecuteCommander returns a commander with a non fatal error handler recording the errors it is passed
+func newExecuteCommander(t *testing.T, handler RequestHandler) (*Commander, *[]error) {
+	c := NewCommander(&cobra.Command{Use: "app", SilenceUsage: true, SilenceErrors: true})
+	if err := c.SetOutputFile(filepath.Join(t.TempDir(), "out.xml")); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := &[]error{}
+	c.SetErrorHandler(func(err error) {
+		*handled = append(*handled, err)
+	})
+
+	err := c.Add(&Command{
+		Name:           "get-task",
+		Request:        &executeRequest{},
+		Response:       &executeResponse{},
+		RequestHandler: handler,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, handled
+}
+
+func TestExecuteArgsReturnsCommandErrors(t *testing.T) {
+	session := &closeRecorder{}
+	var c *Commander
+	c, handled := newExecuteCommander(t, func(ctx context.Context, req, resp interface{}) error {
+		_, _, err := c.Sessions().Get("server", func() (io.Closer, error) {
+			return session, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return &StatusError{Code: 503, Text: "Service temporarily down"}
+	})
+
+	err := c.ExecuteArgs([]string{"get-task", "--name", "weekly"})
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("error %v, want ErrServer", err)
+	}
+	if code := c.ExitCode(err); code != 7 {
+		t.Errorf("exit code %d, want 7", code)
+	}
+	if len(*handled) != 1 || (*handled)[0] != err {
+		t.Errorf("error handler passed %v, want the returned error once", *handled)
+	}
+	if !session.closed {
+		t.Error("session not closed before ExecuteArgs returned")
+	}
+}
+
+func TestExecuteArgsSuccess(t *testing.T) {
+	c, handled := newExecuteCommander(t, func(ctx context.Context, req, resp interface{}) error {
+		resp.(*executeResponse).Name = req.(*executeRequest).Name
+		return nil
+	})
+
+	err := c.ExecuteArgs([]string{"get-task", "--name", "weekly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*handled) != 0 {
+		t.Errorf("error handler passed %v, want nothing", *handled)
+	}
+}
+
+func TestExecuteArgsUsageErrors(t *testing.T) {
+	c, handled := newExecuteCommander(t, func(ctx context.Context, req, resp interface{}) error {
+		return nil
+	})
+
+	for _, args := range [][]string{{"get-task", "--bogus"}, {"bogus"}} {
+		err := c.ExecuteArgs(args)
+		if !errors.Is(err, ErrUsage) {
+			t.Errorf("%v: error %v, want ErrUsage", args, err)
+		}
+	}
+	if len(*handled) != 0 {
+		t.Errorf("error handler passed %v, cli errors are only returned", *handled)
+	}
+}
@@ -0,0 +1,281 @@
+package combi
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Names of the built in output formats
+const (
+	FormatJSON       = "json"
+	FormatYAML       = "yaml"
+	FormatXML        = "xml"
+	FormatXMLCompact = "xml-compact"
+	FormatTable      = "table"
+	FormatCSV        = "csv"
+)
+
+// Formatter renders a response to the supplied writer
+type Formatter func(w io.Writer, resp interface{}) error
+
+/*
+FormatterFactory builds a Formatter from the argument supplied after the format
+name (-o name=arg), cmd is the command being rendered and may be nil when the
+format is only being validated
+*/
+type FormatterFactory func(cmd *Command, arg string) (Formatter, error)
+
+// defaultFormatters returns the built in formatters keyed by name
+func defaultFormatters() map[string]Formatter {
+	return map[string]Formatter{
+		FormatJSON:       JSONFormatter,
+		FormatYAML:       YAMLFormatter,
+		FormatXML:        XMLPrettyFormatter,
+		FormatXMLCompact: XMLCompactFormatter,
+	}
+}
+
+// CodecFormatter returns a Formatter rendering the response with the codec, followed by a new line
+func CodecFormatter(codec Codec) Formatter {
+	return func(w io.Writer, resp interface{}) error {
+		res, err := codec.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("unable to marshal response: %w", err)
+		}
+
+		if len(res) > 0 && res[len(res)-1] != '\n' {
+			res = append(res, '\n')
+		}
+
+		_, err = w.Write(res)
+		return err
+	}
+}
+
+// JSONFormatter renders the response as indented JSON, leaving out xml.Name fields
+var JSONFormatter = WithoutXMLNames(CodecFormatter(JSONCodec{Indent: "  "}))
+
+// YAMLFormatter renders the response as YAML, leaving out xml.Name fields
+var YAMLFormatter = WithoutXMLNames(CodecFormatter(YAMLCodec{}))
+
+/*
+WithoutXMLNames returns a Formatter rendering a copy of the response with the
+xml.Name fields (XMLName) left out, so formats other than XML are not cluttered
+by the XML element names of the response structs
+*/
+func WithoutXMLNames(f Formatter) Formatter {
+	return func(w io.Writer, resp interface{}) error {
+		return f(w, stripXMLNames(resp))
+	}
+}
+
+var (
+	xmlNameType       = reflect.TypeOf(xml.Name{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	yamlMarshalerType = reflect.TypeOf((*yaml.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// strippedType is the cached result of stripType, changed is false when the type is used as is
+type strippedType struct {
+	typ     reflect.Type
+	changed bool
+}
+
+// strippedTypes maps reflect.Type to strippedType
+var strippedTypes sync.Map
+
+// stripXMLNames returns a copy of v without xml.Name fields, or v when it has none
+func stripXMLNames(v interface{}) interface{} {
+	val := reflect.ValueOf(v)
+	if !val.IsValid() {
+		return v
+	}
+
+	t, changed := stripType(val.Type(), map[reflect.Type]bool{})
+	if !changed {
+		return v
+	}
+
+	res := reflect.New(t).Elem()
+	copyStripped(res, val)
+
+	return res.Interface()
+}
+
+/*
+stripType returns the type built from t without xml.Name fields. Types
+marshalling themselves are used as is, as are recursive types once seen
+*/
+func stripType(t reflect.Type, seen map[reflect.Type]bool) (reflect.Type, bool) {
+	if cached, ok := strippedTypes.Load(t); ok {
+		st := cached.(strippedType)
+		return st.typ, st.changed
+	}
+	if seen[t] {
+		return t, false
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, m := range []reflect.Type{jsonMarshalerType, yamlMarshalerType, textMarshalerType} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return t, false
+		}
+	}
+
+	res, changed := t, false
+	switch t.Kind() {
+	case reflect.Ptr:
+		if elem, ok := stripType(t.Elem(), seen); ok {
+			res, changed = reflect.PtrTo(elem), true
+		}
+
+	case reflect.Slice:
+		if elem, ok := stripType(t.Elem(), seen); ok {
+			res, changed = reflect.SliceOf(elem), true
+		}
+
+	case reflect.Array:
+		if elem, ok := stripType(t.Elem(), seen); ok {
+			res, changed = reflect.ArrayOf(t.Len(), elem), true
+		}
+
+	case reflect.Map:
+		if elem, ok := stripType(t.Elem(), seen); ok {
+			res, changed = reflect.MapOf(t.Key(), elem), true
+		}
+
+	case reflect.Interface:
+		// the dynamic value is stripped when copied, if it can be assigned back
+		changed = t.NumMethod() == 0
+
+	case reflect.Struct:
+		fields := []reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Type == xmlNameType {
+				changed = true
+				continue
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			if ft, ok := stripType(sf.Type, seen); ok {
+				sf.Type, changed = ft, true
+			}
+			fields = append(fields, reflect.StructField{Name: sf.Name, Type: sf.Type, Tag: sf.Tag, Anonymous: sf.Anonymous})
+		}
+
+		// embedded types with methods cannot be embedded in a new struct
+		if changed {
+			for i := range fields {
+				ft := fields[i].Type
+				if fields[i].Anonymous && (ft.NumMethod() > 0 || reflect.PtrTo(ft).NumMethod() > 0) {
+					if ft.Kind() != reflect.Struct {
+						return t, false
+					}
+					fields[i].Type = reflect.StructOf(structFields(ft))
+				}
+			}
+			res = reflect.StructOf(fields)
+		}
+	}
+
+	strippedTypes.Store(t, strippedType{typ: res, changed: changed})
+
+	return res, changed
+}
+
+// structFields returns the exported fields of the struct type
+func structFields(t reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath == "" && sf.Type != xmlNameType {
+			fields = append(fields, reflect.StructField{Name: sf.Name, Type: sf.Type, Tag: sf.Tag})
+		}
+	}
+
+	return fields
+}
+
+// copyStripped copies src into dst, a value of the type stripType built from the src type
+func copyStripped(dst, src reflect.Value) {
+	if dst.Type() == src.Type() && (dst.Kind() != reflect.Interface || dst.Type().NumMethod() > 0) {
+		dst.Set(src)
+		return
+	}
+
+	switch src.Kind() {
+	case reflect.Ptr:
+		if !src.IsNil() {
+			elem := reflect.New(dst.Type().Elem())
+			copyStripped(elem.Elem(), src.Elem())
+			dst.Set(elem)
+		}
+
+	case reflect.Slice:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+			for i := 0; i < src.Len(); i++ {
+				copyStripped(dst.Index(i), src.Index(i))
+			}
+		}
+
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyStripped(dst.Index(i), src.Index(i))
+		}
+
+	case reflect.Map:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+			for _, key := range src.MapKeys() {
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				copyStripped(elem, src.MapIndex(key))
+				dst.SetMapIndex(key, elem)
+			}
+		}
+
+	case reflect.Interface:
+		if !src.IsNil() && src.CanInterface() {
+			dst.Set(reflect.ValueOf(stripXMLNames(src.Interface())))
+		}
+
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			copyStripped(dst.Field(i), src.FieldByName(dst.Type().Field(i).Name))
+		}
+	}
+}
+
+// XMLPrettyFormatter renders the response as indented XML
+var XMLPrettyFormatter = CodecFormatter(XMLCodec{Indent: "  "})
+
+// XMLCompactFormatter renders the response as XML on a single line
+var XMLCompactFormatter = CodecFormatter(XMLCodec{})
+
+// formatCell renders a single value for table and csv output
+func formatCell(val reflect.Value) string {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return ""
+		}
+		val = val.Elem()
+	}
+
+	if !val.IsValid() || !val.CanInterface() {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", val.Interface())
+}
@@ -0,0 +1,116 @@
+package combi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type formatTask struct {
+	XMLName xml.Name `xml:"task"`
+	Name    string   `xml:"name" json:"name"`
+}
+
+type formatResponse struct {
+	XMLName xml.Name `xml:"get_tasks_response"`
+	OMPStatus
+	Tasks []formatTask `xml:"task" json:"tasks"`
+	First *formatTask  `xml:"-" json:"first"`
+	Extra interface{}  `xml:"-" json:"extra"`
+}
+
+func newFormatResponse() *formatResponse {
+	return &formatResponse{
+		OMPStatus: OMPStatus{Code: 200, Text: "OK"},
+		Tasks:     []formatTask{{Name: "alpha"}, {Name: "beta"}},
+		First:     &formatTask{Name: "alpha"},
+		Extra:     formatTask{Name: "gamma"},
+	}
+}
+
+func TestJSONFormatterWithoutXMLNames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := JSONFormatter(buf, newFormatResponse()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	if strings.Contains(got, "XMLName") {
+		t.Errorf("json output has XMLName:\n%s", got)
+	}
+	for _, want := range []string{`"status": 200`, `"name": "alpha"`, `"name": "beta"`, `"name": "gamma"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("json output missing %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestYAMLFormatterWithoutXMLNames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := YAMLFormatter(buf, newFormatResponse()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	if strings.Contains(got, "xmlname") {
+		t.Errorf("yaml output has xmlname:\n%s", got)
+	}
+	if !strings.Contains(got, "name: gamma") {
+		t.Errorf("yaml output missing interface value:\n%s", got)
+	}
+}
+
+func TestXMLFormatterKeepsXMLNames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := XMLCompactFormatter(buf, newFormatResponse()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), `<get_tasks_response status="200" status_text="OK">`) {
+		t.Errorf("xml output lost its root element: %s", buf.String())
+	}
+}
+
+// upperCodec is a stand in for an application codec
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(*formatTask).Name)), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	v.(*formatTask).Name = strings.ToLower(string(data))
+	return nil
+}
+
+func (upperCodec) ContentType() string {
+	return "text/x-upper"
+}
+
+func TestRegisterCodecAddsOutputFormat(t *testing.T) {
+	c := NewCommander(&cobra.Command{Use: "app"})
+	c.RegisterFormatter("shout", TableFormatter)
+	c.RegisterCodec("upper", upperCodec{})
+	c.RegisterCodec("shout", upperCodec{})
+
+	f, err := c.Formatter("upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f(&buf, &formatTask{Name: "alpha"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ALPHA\n" {
+		t.Errorf("output %q, want ALPHA", buf.String())
+	}
+
+	// formatters registered under the name are kept
+	buf.Reset()
+	if f, _ = c.Formatter("shout"); f(&buf, &formatTask{Name: "alpha"}) != ErrNotTabular {
+		t.Error("shout formatter replaced by the codec")
+	}
+}
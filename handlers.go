@@ -1,82 +1,192 @@
 package combi
 
 import (
-	"encoding/xml"
-	"errors"
+	"context"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"gopkg.in/abiosoft/ishell.v2"
 )
 
 // GenericStaticHandler provides a generic handler for static cli commands
-var GenericStaticHandler = func(command *Command, cmd *cobra.Command, args []string) error {
+var GenericStaticHandler = func(ctx context.Context, command *Command, cmd *cobra.Command, args []string) error {
 
 	// @TODO remove or make verbose
 	// fmt.Println(cmd.Name())
 
+	err := loadStaticRequestFile(command, cmd)
+	if err != nil {
+		return err
+	}
+
 	// run validation
-	result, err := govalidator.ValidateStruct(command.Request)
+	_, err = govalidator.ValidateStruct(command.Request)
 	if err != nil {
-		println(result)
-		return fmt.Errorf("validation error: %s" + err.Error())
+		return classify(ErrValidation, fmt.Errorf("validation error: %w", err))
 	}
 
-	err = command.HandleRequest(command.Request, command.Response)
+	err = command.HandleRequest(ctx, command.Request, command.Response)
 	if err != nil {
-		return fmt.Errorf("error from request handler: %s", err)
+		return fmt.Errorf("error from request handler: %w", err)
 	}
 
-	return command.HandleResponse(command.Response)
+	return command.WriteResponse(StdoutOutput(), command.Response)
 }
 
 var DefaultRegistrationHandler = func(parentCmd *cobra.Command, cmd *Command) error {
 
 	// generate cobra command to handle static calls
 	staticCmd := cmd.Static()
+
+	// flags are bound to their own instance, leaving the prototype request untouched
+	bound, err := cmd.resetStruct(cmd.Request)
+	if err != nil {
+		return err
+	}
+
 	// fmt.Println("pre-inspect", cmd.Name)
-	fis, err := InspectStruct(cmd.Request)
+	fis, err := InspectStruct(bound)
 	if err != nil {
 		return err
 	}
 
 	// fmt.Printf("%+q", fis)
 
+	err = bindFlags(staticCmd.Flags(), fis)
+	if err != nil {
+		return err
+	}
+
+	// assign command
+	cmd.bound = bound
+	parentCmd.AddCommand(staticCmd)
+
+	return nil
+}
+
+// bindFlags generates flags for the fields with lFlag and hint tags, bound to the inspected value
+func bindFlags(flags *pflag.FlagSet, fis []*FieldInfo) error {
+
 	// loop over fields and generate flags
 	for _, fi := range fis {
 		if fi.LFlag != "" && fi.Hint != "" {
 			switch ptr := fi.FieldPtr.(type) {
 			case *string:
 				if len(fi.SFlag) == 1 {
-					staticCmd.Flags().StringVarP(ptr, fi.LFlag, fi.SFlag, "", fi.Hint)
+					flags.StringVarP(ptr, fi.LFlag, fi.SFlag, "", fi.Hint)
 				} else {
-					staticCmd.Flags().StringVar(ptr, fi.LFlag, "", fi.Hint)
+					flags.StringVar(ptr, fi.LFlag, "", fi.Hint)
 				}
 			case *int:
 				if len(fi.SFlag) == 1 {
-					staticCmd.Flags().IntVarP(ptr, fi.LFlag, fi.SFlag, 0, fi.Hint)
+					flags.IntVarP(ptr, fi.LFlag, fi.SFlag, 0, fi.Hint)
 				} else {
-					staticCmd.Flags().IntVar(ptr, fi.LFlag, 0, fi.Hint)
+					flags.IntVar(ptr, fi.LFlag, 0, fi.Hint)
 				}
 			default:
-				log.Println("returning error")
-				return errors.New("unhandled type")
+				return classify(ErrUsage, fmt.Errorf("unsupported flag type %s for field %s%s", fi.Type, fi.Namespace, fi.Name))
 			}
 
 		}
 	}
 
-	// assign command
-	parentCmd.AddCommand(staticCmd)
+	return nil
+}
+
+/*
+parseShellArgs sets request fields from flags given inline with a shell command,
+e.g. create-task --name "weekly scan", using the same flags as the static cli
+*/
+func parseShellArgs(command *Command, args []string) error {
+	fis, err := InspectStruct(command.Request)
+	if err != nil {
+		return err
+	}
+
+	flags := pflag.NewFlagSet(command.Name, pflag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	err = bindFlags(flags, fis)
+	if err != nil {
+		return err
+	}
+
+	err = flags.Parse(args)
+	if err != nil {
+		return classify(ErrUsage, err)
+	}
+	if flags.NArg() > 0 {
+		return classify(ErrUsage, fmt.Errorf("unexpected argument: %s", flags.Arg(0)))
+	}
 
 	return nil
 }
 
-// DefaultErrorHandler provides a simple fatal error implementation
+/*
+loadStaticRequestFile replaces the invocation request with one loaded from any
+request file, fields of flags given on the command line are copied over it so
+they take precedence
+*/
+func loadStaticRequestFile(command *Command, cmd *cobra.Command) error {
+	if command.Commander.RequestFile() == "" {
+		return nil
+	}
+
+	changed := map[string]bool{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		changed[f.Name] = true
+	})
+
+	req, err := command.resetStruct(command.Request)
+	if err != nil {
+		return err
+	}
+
+	err = command.Commander.LoadRequestFile(req)
+	if err != nil {
+		return err
+	}
+
+	// both requests share a type, so their fields line up
+	flagged, err := InspectStruct(command.Request)
+	if err != nil {
+		return err
+	}
+	loaded, err := InspectStruct(req)
+	if err != nil {
+		return err
+	}
+	for i, fi := range flagged {
+		if fi.LFlag != "" && changed[fi.LFlag] {
+			loaded[i].Field.Set(fi.Field)
+		}
+	}
+
+	command.Request = req
+
+	return nil
+}
+
+/*
+DefaultErrorHandler prints static mode errors to stderr and exits with the
+default code for the error class, commanders use their own mapping (see
+Commander.SetExitCode) unless another handler is set
+*/
 var DefaultErrorHandler = func(err error) {
-	log.Fatal(err)
+	printStaticError(err)
+	os.Exit(exitCodeFor(defaultExitCodes(), err))
+}
+
+func printStaticError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+}
+
+// DefaultShellErrorHandler prints shell mode errors, returning control to the prompt
+var DefaultShellErrorHandler = func(c *ishell.Context, err error) {
+	shellPrintError(c, err)
 }
 
 /*
@@ -84,7 +194,18 @@ GenericShellHandler provides a generic handler for shell commands, uses reflecti
 to identify required fields and prompt user for values via shell, may need bypassing
 for more advanced structs
 */
-var GenericShellHandler = func(command *Command, c *ishell.Context) error {
+var GenericShellHandler = func(ctx context.Context, command *Command, c *ishell.Context) error {
+
+	err := command.Commander.LoadRequestFile(command.Request)
+	if err != nil {
+		return err
+	}
+
+	// inline flags take precedence over the request file
+	err = parseShellArgs(command, c.Args)
+	if err != nil {
+		return err
+	}
 
 	fis, err := InspectStruct(command.Request)
 	if err != nil {
@@ -95,17 +216,30 @@ var GenericShellHandler = func(command *Command, c *ishell.Context) error {
 	// fmt.Printf("%q\n", fis)
 
 	required, optional := splitRequiredFields(fis)
+	scripted := command.Commander.Scripting()
 
-	// collect values for all required fields
+	// collect values for all required fields not already set from a request file or inline flags
 	for _, fi := range required {
+		if !fi.Zero {
+			continue
+		}
+
+		// scripts cannot answer prompts
+		if scripted {
+			return classify(ErrValidation, fmt.Errorf("missing required field %s%s", fi.Namespace, fi.Name))
+		}
+
 		err = collectShellValue(c, fi)
 		if err != nil {
 			return err
 		}
 	}
 
-	// once we have collected all required vals, present optional fields
+	// once we have collected all required vals, present optional fields unless given inline
 	selected := 1
+	if scripted || len(c.Args) > 0 {
+		selected = -1
+	}
 	for selected >= 0 {
 		selected = presentOptions(c, optional)
 		if selected >= 0 {
@@ -116,34 +250,20 @@ var GenericShellHandler = func(command *Command, c *ishell.Context) error {
 		}
 	}
 
-	err = command.HandleRequest(command.Request, command.Response)
+	err = command.HandleRequest(ctx, command.Request, command.Response)
 	if err != nil {
-		return fmt.Errorf("error from request handler: %s", err)
+		return fmt.Errorf("error from request handler: %w", err)
 	}
 
-	return command.HandleResponse(command.Response)
+	return command.WriteResponse(ShellOutput(c), command.Response)
 }
 
-func XMLCompactPrintResponseHandler(resp interface{}) error {
-
-	res, err := xml.Marshal(resp)
-	if err != nil {
-		return fmt.Errorf("unable to marshal response: %s", err)
-	}
-
-	fmt.Println(string(res))
-
-	return nil
+// XMLCompactPrintResponseHandler writes the response to the output as compact XML
+func XMLCompactPrintResponseHandler(out *Output, resp interface{}) error {
+	return XMLCompactFormatter(out, resp)
 }
 
-func XMLPrettyPrintResponseHandler(resp interface{}) error {
-
-	res, err := xml.MarshalIndent(resp, "", "  ")
-	if err != nil {
-		return fmt.Errorf("unable to marshal response: %s", err)
-	}
-
-	fmt.Println(string(res))
-	// fmt.Println("+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
-	return nil
+// XMLPrettyPrintResponseHandler writes the response to the output as indented XML
+func XMLPrettyPrintResponseHandler(out *Output, resp interface{}) error {
+	return XMLPrettyFormatter(out, resp)
 }
@@ -0,0 +1,370 @@
+package combi
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+/*
+HTTPTransport provides a RequestHandler for REST APIs. The endpoint is taken
+from the executing Command (HTTPMethod / HTTPPath) and request fields are
+mapped using the http struct tag:
+
+	ID    string `http:"path:id"`          fills {id} in the HTTPPath template
+	Limit int    `http:"query:limit"`      added to the query string when not zero
+	Token string `http:"header:X-Token"`   sent as a header when not empty
+	Name  string `json:"name"`             untagged fields make up the request body
+
+Fields of embedded structs are mapped the same way and promoted in the body.
+The body is encoded with the transport Codec, or the commander codec (--codec)
+when not set. The reply body (including error replies) is decoded into the
+response using the codec matching its content type. Non 2xx replies are
+returned as a *HTTPError
+*/
+type HTTPTransport struct {
+	// BaseURL is prefixed to the command HTTPPath
+	BaseURL string
+
+	// Client is used to send requests, http.DefaultClient when nil
+	Client *http.Client
+
+	// Codec encodes the request body, the commander codec is used when nil
+	Codec Codec
+
+	// Header is sent with every request
+	Header http.Header
+}
+
+// RequestHandler returns the transport as a RequestHandler
+func (t *HTTPTransport) RequestHandler() RequestHandler {
+	return t.Handle
+}
+
+// Handle builds the HTTP request from the executing command and request, sends it and decodes the reply
+func (t *HTTPTransport) Handle(ctx context.Context, req, resp interface{}) error {
+	cmd, ok := CommandFromContext(ctx)
+	if !ok {
+		return errors.New("http transport must be called through a command")
+	}
+
+	httpReq, err := t.newRequest(ctx, cmd, req)
+	if err != nil {
+		return err
+	}
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return transportError(ctx, fmt.Errorf("unable to send request: %w", err))
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return transportError(ctx, fmt.Errorf("unable to read response: %w", err))
+	}
+
+	// decode error replies too, so error details can be presented
+	var decodeErr error
+	if len(bytes.TrimSpace(body)) > 0 {
+		codec := t.codec(cmd)
+		if c, ok := cmd.Commander.CodecForContentType(httpResp.Header.Get("Content-Type")); ok {
+			codec = c
+		}
+		decodeErr = codec.Unmarshal(body, resp)
+	}
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return &HTTPError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: body}
+	}
+	if decodeErr != nil {
+		return classify(ErrTransport, fmt.Errorf("unable to decode response: %w", decodeErr))
+	}
+
+	return nil
+}
+
+// newRequest maps the request struct onto the command endpoint
+func (t *HTTPTransport) newRequest(ctx context.Context, cmd *Command, req interface{}) (*http.Request, error) {
+	val := reflect.Indirect(reflect.ValueOf(req))
+	if val.Kind() != reflect.Struct {
+		return nil, ErrStructPtrExpected
+	}
+
+	path := cmd.HTTPPath
+	if path == "" {
+		path = "/" + cmd.Name
+	}
+
+	query := url.Values{}
+	header := http.Header{}
+	bodyFields := [][]int{}
+
+	for _, index := range httpFields(val.Type(), nil) {
+		sf := val.Type().FieldByIndex(index)
+
+		tag := sf.Tag.Get("http")
+		if tag == "" {
+			bodyFields = append(bodyFields, index)
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid http tag on %s: %s", sf.Name, tag)
+		}
+
+		field := val.FieldByIndex(index)
+		switch parts[0] {
+		case "path":
+			placeholder := "{" + parts[1] + "}"
+			if !strings.Contains(path, placeholder) {
+				return nil, fmt.Errorf("path %s has no placeholder %s", path, placeholder)
+			}
+			path = strings.Replace(path, placeholder, url.PathEscape(formatCell(field)), -1)
+
+		case "query":
+			if isList(field) {
+				for j := 0; j < field.Len(); j++ {
+					query.Add(parts[1], formatCell(field.Index(j)))
+				}
+			} else if !isZero(field) {
+				query.Set(parts[1], formatCell(field))
+			}
+
+		case "header":
+			if !isZero(field) {
+				header.Set(parts[1], formatCell(field))
+			}
+
+		default:
+			return nil, fmt.Errorf("invalid http tag on %s: %s", sf.Name, tag)
+		}
+	}
+
+	if strings.Contains(path, "{") {
+		return nil, fmt.Errorf("unfilled placeholder in path %s", path)
+	}
+
+	method := cmd.HTTPMethod
+	if method == "" {
+		method = http.MethodGet
+		if len(bodyFields) > 0 {
+			method = http.MethodPost
+		}
+	}
+
+	target := strings.TrimSuffix(t.BaseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var body []byte
+	if len(bodyFields) > 0 && method != http.MethodGet && method != http.MethodHead {
+		var err error
+		body, err = t.encodeBody(t.codec(cmd), val, bodyFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpReq, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %w", err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	for name, values := range t.Header {
+		httpReq.Header[name] = values
+	}
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", t.codec(cmd).ContentType())
+	}
+	httpReq.Header.Set("Accept", t.codec(cmd).ContentType())
+
+	return httpReq, nil
+}
+
+/*
+httpFields returns the index paths of the exported fields of the struct type,
+embedded structs holding http tagged fields (or of unexported types) are
+descended into so their fields can be mapped too, other embedded structs are
+kept whole
+*/
+func httpFields(t reflect.Type, parent []int) [][]int {
+	res := [][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+
+		embedded := sf.Anonymous && sf.Type.Kind() == reflect.Struct
+		if sf.PkgPath != "" && !embedded {
+			continue
+		}
+		if embedded && (sf.PkgPath != "" || (sf.Tag.Get("http") == "" && hasHTTPTags(sf.Type))) {
+			res = append(res, httpFields(sf.Type, index)...)
+			continue
+		}
+		res = append(res, index)
+	}
+
+	return res
+}
+
+// hasHTTPTags reports whether the struct type, or a struct it embeds, has http tagged fields
+func hasHTTPTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("http") != "" {
+			return true
+		}
+		if sf.Anonymous && sf.PkgPath == "" && sf.Type.Kind() == reflect.Struct && hasHTTPTags(sf.Type) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+encodeBody encodes only the body fields of the request, a struct type holding
+just those fields (with their tags) is built so the codec honours the tags
+*/
+func (t *HTTPTransport) encodeBody(codec Codec, val reflect.Value, fields [][]int) ([]byte, error) {
+	bodyFields := [][]int{}
+	xmlRoot := ""
+	if isXMLCodec(codec) {
+		// name the root element after the request type, unless XMLName is set
+		xmlRoot = val.Type().Name()
+	}
+
+	for _, index := range fields {
+		if len(index) == 1 && val.Type().Field(index[0]).Name == "XMLName" {
+			if xmlRoot == "" {
+				continue
+			}
+			xmlRoot = ""
+		}
+		bodyFields = append(bodyFields, index)
+	}
+
+	res, err := codec.Marshal(subsetStruct(val, bodyFields, xmlRoot))
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal request: %w", err)
+	}
+
+	return res, nil
+}
+
+/*
+subsetStruct copies the fields at the given index paths of a struct value, with
+their tags, into a new anonymous struct, xmlRoot adds an XMLName field naming
+the root element. Embedded structs stay embedded so codecs promote their
+fields, fields of embedded structs are promoted into the new struct, unless a
+shallower field has the same name
+*/
+func subsetStruct(val reflect.Value, fields [][]int, xmlRoot string) interface{} {
+	structFields := []reflect.StructField{}
+	if xmlRoot != "" {
+		structFields = append(structFields, reflect.StructField{
+			Name: "XMLName",
+			Type: reflect.TypeOf(xml.Name{}),
+			Tag:  reflect.StructTag(`xml:"` + xmlRoot + `"`),
+		})
+	}
+	offset := len(structFields)
+
+	// expand embedded structs reflect cannot embed in a new struct
+	expanded := [][]int{}
+	for _, index := range fields {
+		expanded = append(expanded, embeddableFields(val.Type(), index)...)
+	}
+
+	// shallower fields shadow promoted fields of the same name
+	depths := map[string]int{}
+	for _, index := range expanded {
+		name := val.Type().FieldByIndex(index).Name
+		if d, ok := depths[name]; !ok || len(index) < d {
+			depths[name] = len(index)
+		}
+	}
+
+	kept := [][]int{}
+	for _, index := range expanded {
+		sf := val.Type().FieldByIndex(index)
+		if depths[sf.Name] != len(index) {
+			continue
+		}
+		delete(depths, sf.Name)
+
+		kept = append(kept, index)
+		structFields = append(structFields, reflect.StructField{
+			Name:      sf.Name,
+			Type:      sf.Type,
+			Tag:       sf.Tag,
+			Anonymous: sf.Anonymous,
+		})
+	}
+
+	res := reflect.New(reflect.StructOf(structFields)).Elem()
+	for j, index := range kept {
+		res.Field(offset + j).Set(val.FieldByIndex(index))
+	}
+
+	return res.Interface()
+}
+
+/*
+embeddableFields returns the index path unless it is an embedded struct with
+methods or of an unexported type, which reflect cannot embed in a new struct,
+these are replaced by the index paths of their exported fields
+*/
+func embeddableFields(t reflect.Type, index []int) [][]int {
+	sf := t.FieldByIndex(index)
+	if !sf.Anonymous || sf.Type.Kind() != reflect.Struct ||
+		(sf.PkgPath == "" && sf.Type.NumMethod() == 0 && reflect.PtrTo(sf.Type).NumMethod() == 0) {
+		return [][]int{index}
+	}
+
+	res := [][]int{}
+	for i := 0; i < sf.Type.NumField(); i++ {
+		if f := sf.Type.Field(i); f.PkgPath != "" && !(f.Anonymous && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		res = append(res, embeddableFields(t, append(append([]int{}, index...), i))...)
+	}
+
+	return res
+}
+
+// codec returns the transport codec, falling back to the commander codec
+func (t *HTTPTransport) codec(cmd *Command) Codec {
+	if t.Codec != nil {
+		return t.Codec
+	}
+
+	return cmd.Commander.DefaultCodec()
+}
+
+// isZero reports whether the value is the zero value for its type
+func isZero(val reflect.Value) bool {
+	return reflect.DeepEqual(val.Interface(), reflect.Zero(val.Type()).Interface())
+}
@@ -0,0 +1,273 @@
+package combi
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type paging struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type scope struct {
+	Project string `http:"header:X-Project"`
+	Owner   string `json:"owner"`
+}
+
+type updateTaskRequest struct {
+	ID   string `http:"path:id"`
+	Name string `json:"name"`
+	paging
+	scope
+}
+
+type updateTaskResponse struct {
+	Status string `json:"status"`
+}
+
+// newHTTPCommand registers the command with the transport as its request handler
+func newHTTPCommand(t *testing.T, transport *HTTPTransport, cmd *Command) *Command {
+	cmd.RequestHandler = transport.RequestHandler()
+	if err := NewCommander(&cobra.Command{Use: "app"}).Add(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd
+}
+
+func TestHTTPTransportEmbeddedStructs(t *testing.T) {
+	var gotPath, gotProject string
+	var gotBody map[string]interface{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotProject = r.Header.Get("X-Project")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &gotBody); err != nil {
+			t.Errorf("request body %s: %s", body, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	cmd := newHTTPCommand(t, &HTTPTransport{BaseURL: srv.URL, Codec: JSONCodec{}}, &Command{
+		Name:       "update-task",
+		HTTPMethod: http.MethodPut,
+		HTTPPath:   "/tasks/{id}",
+		Request:    &updateTaskRequest{},
+		Response:   &updateTaskResponse{},
+	})
+
+	req := &updateTaskRequest{
+		ID:     "42",
+		Name:   "weekly",
+		paging: paging{Limit: 5},
+		scope:  scope{Project: "infra", Owner: "ops"},
+	}
+	resp := &updateTaskResponse{}
+	if err := cmd.HandleRequest(context.Background(), req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotPath != "/tasks/42" {
+		t.Errorf("path %s, want /tasks/42", gotPath)
+	}
+	if gotProject != "infra" {
+		t.Errorf("X-Project header %q, want infra", gotProject)
+	}
+
+	want := map[string]interface{}{"name": "weekly", "limit": float64(5), "owner": "ops"}
+	if len(gotBody) != len(want) {
+		t.Errorf("body %v, want %v", gotBody, want)
+	}
+	for k, v := range want {
+		if gotBody[k] != v {
+			t.Errorf("body %s = %v, want %v", k, gotBody[k], v)
+		}
+	}
+
+	if resp.Status != "ok" {
+		t.Errorf("response status %q, want ok", resp.Status)
+	}
+}
+
+type listTasksRequest struct {
+	Status string `http:"query:status"`
+	Limit  int    `http:"query:limit"`
+	Owner  string `http:"query:owner"`
+	Token  string `http:"header:X-Token"`
+	Trace  string `http:"header:X-Trace"`
+	Secret string `http:"-"`
+}
+
+func TestHTTPTransportQueryAndHeaders(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	transport := &HTTPTransport{BaseURL: srv.URL + "/", Codec: JSONCodec{}, Header: http.Header{"X-Client": {"combi"}}}
+	cmd := newHTTPCommand(t, transport, &Command{
+		Name:     "list-tasks",
+		HTTPPath: "/tasks",
+		Request:  &listTasksRequest{},
+		Response: &updateTaskResponse{},
+	})
+
+	req := &listTasksRequest{Status: "done", Limit: 5, Token: "abc", Secret: "hidden"}
+	if err := cmd.HandleRequest(context.Background(), req, &updateTaskResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without body fields the request is a GET
+	if got.Method != http.MethodGet || got.URL.Path != "/tasks" || got.ContentLength != 0 {
+		t.Errorf("%s %s with %d byte body, want GET /tasks without a body", got.Method, got.URL.Path, got.ContentLength)
+	}
+
+	query := got.URL.Query()
+	if query.Get("status") != "done" || query.Get("limit") != "5" {
+		t.Errorf("query %v, want status done and limit 5", query)
+	}
+	if _, ok := query["owner"]; ok {
+		t.Error("zero owner sent in the query")
+	}
+	if len(query) != 2 {
+		t.Errorf("query %v, want only status and limit", query)
+	}
+
+	if got.Header.Get("X-Token") != "abc" || got.Header.Get("X-Client") != "combi" {
+		t.Errorf("headers %v, want X-Token abc and X-Client combi", got.Header)
+	}
+	if _, ok := got.Header["X-Trace"]; ok {
+		t.Error("empty X-Trace header sent")
+	}
+	if got.Header.Get("Accept") != "application/json" {
+		t.Errorf("accept %q, want the codec content type", got.Header.Get("Accept"))
+	}
+}
+
+type createTargetError struct {
+	Message string `json:"message"`
+}
+
+func TestHTTPTransportErrorBody(t *testing.T) {
+	tests := []struct {
+		code  int
+		class error
+	}{
+		{http.StatusUnprocessableEntity, ErrServer},
+		{http.StatusForbidden, ErrAuth},
+	}
+
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(test.code)
+			w.Write([]byte(`{"message":"name already taken"}`))
+		}))
+
+		// the reply is decoded by its content type, whatever the transport codec
+		cmd := newHTTPCommand(t, &HTTPTransport{BaseURL: srv.URL, Codec: XMLCodec{}}, &Command{
+			Name:     "create-target",
+			Request:  &createTarget{},
+			Response: &createTargetError{},
+		})
+
+		resp := &createTargetError{}
+		err := cmd.HandleRequest(context.Background(), &createTarget{Name: "lab"}, resp)
+		srv.Close()
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			t.Errorf("%d: error %v, want a *HTTPError", test.code, err)
+			continue
+		}
+		if httpErr.StatusCode != test.code || string(httpErr.Body) != `{"message":"name already taken"}` {
+			t.Errorf("%d: error status %d body %s", test.code, httpErr.StatusCode, httpErr.Body)
+		}
+		if !errors.Is(err, test.class) {
+			t.Errorf("%d: error %v, want %v", test.code, err, test.class)
+		}
+		if resp.Message != "name already taken" {
+			t.Errorf("%d: response message %q, want the error body decoded", test.code, resp.Message)
+		}
+	}
+}
+
+type createTarget struct {
+	Name  string `xml:"name"`
+	Hosts string `xml:"hosts"`
+}
+
+type createSchedule struct {
+	XMLName xml.Name `xml:"create_schedule"`
+	Name    string   `xml:"name,attr"`
+	Project string   `http:"header:X-Project"`
+}
+
+type createTargetResponse struct {
+	XMLName xml.Name `xml:"create_target_response"`
+	ID      string   `xml:"id,attr"`
+}
+
+func TestHTTPTransportXMLBody(t *testing.T) {
+	tests := []struct {
+		req  interface{}
+		want string
+	}{
+		// the root element is named after the request type, unless XMLName is set
+		{&createTarget{Name: "lab", Hosts: "10.0.0.0/24"}, `<createTarget><name>lab</name><hosts>10.0.0.0/24</hosts></createTarget>`},
+		{&createSchedule{Name: "nightly", Project: "infra"}, `<create_schedule name="nightly"></create_schedule>`},
+	}
+
+	for _, test := range tests {
+		var body, contentType, project string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			body, contentType, project = string(data), r.Header.Get("Content-Type"), r.Header.Get("X-Project")
+
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.Write([]byte(`<create_target_response id="5"/>`))
+		}))
+
+		cmd := newHTTPCommand(t, &HTTPTransport{BaseURL: srv.URL, Codec: XMLCodec{}}, &Command{
+			Name:     "create-target",
+			Request:  test.req,
+			Response: &createTargetResponse{},
+		})
+
+		resp := &createTargetResponse{}
+		err := cmd.HandleRequest(context.Background(), test.req, resp)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body != test.want {
+			t.Errorf("body %s, want %s", body, test.want)
+		}
+		if contentType != "application/xml" {
+			t.Errorf("content type %q, want application/xml", contentType)
+		}
+		if _, ok := test.req.(*createSchedule); ok && project != "infra" {
+			t.Errorf("X-Project header %q, want infra", project)
+		}
+		if resp.ID != "5" {
+			t.Errorf("response id %q, want 5", resp.ID)
+		}
+	}
+}
@@ -2,9 +2,9 @@ package combi
 
 import (
 	"errors"
-	"log"
 	"reflect"
 	"strings"
+	"sync"
 	// "github.com/davecgh/go-spew/spew"
 )
 
@@ -36,6 +36,11 @@ var (
 	ErrPtrExpected       = errors.New("pointer expected")
 )
 
+/*
+InspectStruct returns the field infos of the struct obj points to, bound to
+obj so FieldPtr / Field set its fields. The field metadata (tags, paths, kinds
+and requiredness) is built once per type and cached
+*/
 func InspectStruct(obj interface{}) ([]*FieldInfo, error) {
 
 	// validate is pointer
@@ -50,19 +55,36 @@ func InspectStruct(obj interface{}) ([]*FieldInfo, error) {
 		return nil, ErrStructPtrExpected
 	}
 
-	// set up index and slice refs
-	index := 0
-	fieldInfos := []*FieldInfo{}
-
-	// inspect fields (recursive)
-	err := inspect(&fieldInfos, &index, "", reflect.StructField{}, ptrVal)
+	meta, err := structMetaFor(val.Type())
 	if err != nil {
 		return nil, err
 	}
 
-	// fmt.Println("===============================================================")
-	// spew.Dump(fieldInfos)
-	// fmt.Println("===============================================================")
+	// bind the cached metadata to this value
+	fieldInfos := make([]*FieldInfo, len(meta))
+	for i, fm := range meta {
+		field := val.FieldByIndex(fm.path)
+
+		fi := &FieldInfo{
+			Index:     i,
+			Required:  fm.required,
+			Namespace: fm.namespace,
+			Name:      fm.name,
+			Hint:      fm.hint,
+			SFlag:     fm.sFlag,
+			LFlag:     fm.lFlag,
+			Type:      fm.typ,
+			Tags:      fm.tags,
+			Field:     field,
+			FieldPtr:  field.Addr().Interface(),
+			Value:     field.Interface(),
+		}
+
+		// check if the value is the zero value for its type
+		fi.Zero = fi.Value == fm.zero
+
+		fieldInfos[i] = fi
+	}
 
 	return fieldInfos, nil
 }
@@ -73,22 +95,57 @@ var excludeFieldNames = map[string]struct{}{
 	"Space":   struct{}{},
 }
 
-/*
-inspect uses reflection to pull field data from the underlying value,
-intended to analyse command request / response objects
-@TODO needs further development - not bulletproof
-*/
-func inspect(fields *[]*FieldInfo, index *int, ns string, typeField reflect.StructField, ptrVal reflect.Value) error {
+// fieldMeta holds the per type metadata of a field found by InspectStruct
+type fieldMeta struct {
+	path      []int
+	required  bool
+	namespace string
+	name      string
+	hint      string
+	sFlag     string
+	lFlag     string
+	typ       reflect.Type
+	tags      reflect.StructTag
+	zero      interface{}
+}
 
-	// first check we have a pointer, otherwise we cant dereference & set value
-	if ptrVal.Kind() != reflect.Ptr {
-		log.Println(ptrVal.Kind())
-		return errors.New("reflection to set value on non-pointer")
+// structMeta is the cached result of inspecting a type
+type structMeta struct {
+	fields []fieldMeta
+	err    error
+}
+
+// structMetaCache maps reflect.Type to *structMeta
+var structMetaCache sync.Map
+
+// structMetaFor returns the cached field metadata for the struct type, inspecting it on first use
+func structMetaFor(t reflect.Type) ([]fieldMeta, error) {
+	if cached, ok := structMetaCache.Load(t); ok {
+		sm := cached.(*structMeta)
+		return sm.fields, sm.err
 	}
 
-	val := reflect.Indirect(ptrVal)
+	sm := &structMeta{fields: []fieldMeta{}}
+	sm.err = inspect(&sm.fields, "", reflect.StructField{}, t, nil)
+	if sm.err != nil {
+		sm.fields = nil
+	}
+
+	// concurrent first uses build identical metadata, keep whichever was stored first
+	cached, _ := structMetaCache.LoadOrStore(t, sm)
+	sm = cached.(*structMeta)
 
-	switch val.Kind() {
+	return sm.fields, sm.err
+}
+
+/*
+inspect uses reflection to pull field data from the type, intended to analyse
+command request / response objects
+@TODO needs further development - not bulletproof
+*/
+func inspect(fields *[]fieldMeta, ns string, typeField reflect.StructField, t reflect.Type, path []int) error {
+
+	switch t.Kind() {
 	case reflect.Int, reflect.String, reflect.Bool:
 
 		// ignore XMLName and other internals
@@ -98,56 +155,41 @@ func inspect(fields *[]*FieldInfo, index *int, ns string, typeField reflect.Stru
 		}
 
 		// collect field info
-		field := &FieldInfo{}
-		field.FieldPtr = ptrVal.Interface()
-		field.Index = *index
-		field.Namespace = ns
-		field.Name = typeField.Name
-		field.Field = val
-		field.Type = val.Type()
-		field.Tags = typeField.Tag
-		validTag := field.Tags.Get("valid")
-		field.SFlag = field.Tags.Get("sFlag")
-		field.LFlag = field.Tags.Get("lFlag")
-		field.Hint = field.Tags.Get("hint")
-		// log.Println(field.Hint)
+		field := fieldMeta{}
+		field.path = append([]int{}, path...)
+		field.namespace = ns
+		field.name = typeField.Name
+		field.typ = t
+		field.tags = typeField.Tag
+		validTag := field.tags.Get("valid")
+		field.sFlag = field.tags.Get("sFlag")
+		field.lFlag = field.tags.Get("lFlag")
+		field.hint = field.tags.Get("hint")
+		field.zero = reflect.Zero(t).Interface()
 
 		// check if field is marked as required
 		if validTag != "" {
 			if strings.Index(validTag, "required") == 0 {
-				field.Required = true
+				field.required = true
 			}
 		}
 
-		// check if the value is the zero value for its type
-		field.Value = val.Interface()
-		if field.Value == reflect.Zero(reflect.TypeOf(field.Value)).Interface() {
-			field.Zero = true
-		}
-
-		if field.Type == nil {
-			log.Fatal("boo")
-		}
-
-		// set field then inc index for next
 		*fields = append(*fields, field)
-		*index++
 
 		return nil
 
 	case reflect.Struct:
 
 		// Iterate over the struct fields and call recursively
-		for i := 0; i < val.NumField(); i++ {
+		for i := 0; i < t.NumField(); i++ {
 			newNS := ""
 
-			// fieldName := val.Type().Field(i).Name
 			fieldName := typeField.Name
 			if typeField.Name != "" {
 				newNS = ns + fieldName + "->"
 			}
 
-			err := inspect(fields, index, newNS, val.Type().Field(i), val.Field(i).Addr())
+			err := inspect(fields, newNS, t.Field(i), t.Field(i).Type, append(path, i))
 			if err != nil {
 				return err
 			}
@@ -156,7 +198,7 @@ func inspect(fields *[]*FieldInfo, index *int, ns string, typeField reflect.Stru
 		return nil
 
 	default:
-		return errors.New("Unsupported kind: " + val.Kind().String())
+		return errors.New("Unsupported kind: " + t.Kind().String())
 	}
 }
 
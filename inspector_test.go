@@ -0,0 +1,153 @@
+package combi
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type inspectTarget struct {
+	Host  string `sFlag:"H" lFlag:"host" hint:"target host" valid:"required"`
+	Ports string `lFlag:"ports"`
+}
+
+type inspectSchedule struct {
+	Name  string `lFlag:"schedule-name"`
+	Count int    `lFlag:"count" valid:"required,range(1|10)"`
+}
+
+type inspectRequest struct {
+	XMLName   xml.Name `xml:"create_task"`
+	Name      string   `lFlag:"name" hint:"task name" valid:"required"`
+	Comment   string   `lFlag:"comment"`
+	Alterable bool     `lFlag:"alterable"`
+	Target    inspectTarget
+	Schedule  inspectSchedule
+}
+
+func newInspectRequest() *inspectRequest {
+	return &inspectRequest{
+		Name:     "scan",
+		Target:   inspectTarget{Host: "10.0.0.1"},
+		Schedule: inspectSchedule{Count: 3},
+	}
+}
+
+// walkStruct is the uncached walk InspectStruct replaced, kept as the reference
+func walkStruct(fields *[]*FieldInfo, ns string, typeField reflect.StructField, ptrVal reflect.Value) {
+	val := reflect.Indirect(ptrVal)
+
+	switch val.Kind() {
+	case reflect.Int, reflect.String, reflect.Bool:
+		if _, ok := excludeFieldNames[typeField.Name]; ok {
+			return
+		}
+
+		fi := &FieldInfo{
+			Index:     len(*fields),
+			Namespace: ns,
+			Name:      typeField.Name,
+			Hint:      typeField.Tag.Get("hint"),
+			SFlag:     typeField.Tag.Get("sFlag"),
+			LFlag:     typeField.Tag.Get("lFlag"),
+			Type:      val.Type(),
+			Tags:      typeField.Tag,
+			Field:     val,
+			FieldPtr:  ptrVal.Interface(),
+			Value:     val.Interface(),
+		}
+		fi.Required = strings.Index(typeField.Tag.Get("valid"), "required") == 0
+		fi.Zero = fi.Value == reflect.Zero(val.Type()).Interface()
+		*fields = append(*fields, fi)
+
+	case reflect.Struct:
+		newNS := ""
+		if typeField.Name != "" {
+			newNS = ns + typeField.Name + "->"
+		}
+		for i := 0; i < val.NumField(); i++ {
+			walkStruct(fields, newNS, val.Type().Field(i), val.Field(i).Addr())
+		}
+	}
+}
+
+func TestInspectStructMatchesWalk(t *testing.T) {
+
+	// the second call uses the cached metadata
+	for call := 0; call < 2; call++ {
+		req := newInspectRequest()
+
+		got, err := InspectStruct(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []*FieldInfo{}
+		walkStruct(&want, "", reflect.StructField{}, reflect.ValueOf(req))
+
+		if len(got) != len(want) {
+			t.Fatalf("call %d: got %d fields, want %d", call, len(got), len(want))
+		}
+
+		for i := range want {
+			g, w := got[i], want[i]
+			if g.Index != w.Index || g.Name != w.Name || g.Namespace != w.Namespace ||
+				g.Required != w.Required || g.Zero != w.Zero || g.Hint != w.Hint ||
+				g.SFlag != w.SFlag || g.LFlag != w.LFlag || g.Type != w.Type ||
+				g.Tags != w.Tags || g.Value != w.Value {
+				t.Errorf("call %d field %d: got %+v, want %+v", call, i, g, w)
+			}
+			if g.FieldPtr != w.FieldPtr {
+				t.Errorf("call %d field %d (%s): pointer not bound to the request", call, i, w.Name)
+			}
+		}
+	}
+}
+
+func TestInspectStructPointersSetFields(t *testing.T) {
+	req := newInspectRequest()
+
+	fis, err := InspectStruct(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range fis {
+		switch ptr := fi.FieldPtr.(type) {
+		case *string:
+			*ptr = "set-" + fi.Name
+		case *int:
+			*ptr = 7
+		case *bool:
+			*ptr = true
+		}
+	}
+
+	if req.Name != "set-Name" || req.Target.Host != "set-Host" || req.Schedule.Count != 7 || !req.Alterable {
+		t.Errorf("fields not set through FieldPtr: %+v", req)
+	}
+}
+
+func BenchmarkInspectStruct(b *testing.B) {
+	typ := reflect.TypeOf(inspectRequest{})
+
+	b.Run("first", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			structMetaCache.Delete(typ)
+			if _, err := InspectStruct(newInspectRequest()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := InspectStruct(newInspectRequest()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
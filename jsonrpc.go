@@ -0,0 +1,271 @@
+package combi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+)
+
+/*
+JSONRPCTransport provides a RequestHandler for JSON-RPC 2.0 services over HTTP.
+The method is the name of the executing command, unless the request declares
+one with the jsonrpc struct tag on any field (conventionally a struct{} field):
+
+	type ListTasks struct {
+		Method struct{} `jsonrpc:"tasks.list"`
+		Limit  int      `json:"limit"`
+	}
+
+The remaining request fields are sent as the params, the result is decoded
+into the response and error replies are returned as a *RPCError
+*/
+type JSONRPCTransport struct {
+	// URL of the JSON-RPC endpoint
+	URL string
+
+	// Client is used to send requests, http.DefaultClient when nil
+	Client *http.Client
+
+	// Header is sent with every request
+	Header http.Header
+
+	lastID uint64
+}
+
+// rpcRequest is a JSON-RPC request object
+type rpcRequest struct {
+	Version string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC response object
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// RPCCall is a single call within an RPCBatch, Err holds the outcome once the batch is sent
+type RPCCall struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Err    error
+
+	id uint64
+}
+
+// RequestHandler returns the transport as a RequestHandler
+func (t *JSONRPCTransport) RequestHandler() RequestHandler {
+	return t.Handle
+}
+
+// Handle calls the method for the executing command, decoding the result into the response
+func (t *JSONRPCTransport) Handle(ctx context.Context, req, resp interface{}) error {
+	name := ""
+	var batch *scriptBatch
+	if cmd, ok := CommandFromContext(ctx); ok {
+		name = cmd.Name
+		if cmd.Commander != nil {
+			batch = cmd.Commander.scriptBatch()
+		}
+	}
+
+	call, err := t.newCall(name, req, resp)
+	if err != nil {
+		return err
+	}
+
+	// within a script batch block the call is sent with the others in the block
+	if batch != nil {
+		if queued := batch.queue(t, call); queued != nil {
+			return queued.wait(ctx)
+		}
+	}
+
+	var reply rpcResponse
+	err = t.post(ctx, t.request(call), &reply)
+	if err != nil {
+		return err
+	}
+
+	return call.complete(reply)
+}
+
+// NewBatch returns a batch of calls to be sent to the service in a single request
+func (t *JSONRPCTransport) NewBatch() *RPCBatch {
+	return &RPCBatch{transport: t}
+}
+
+// newCall builds a call from a request struct, resolving the method and params
+func (t *JSONRPCTransport) newCall(method string, req, resp interface{}) (*RPCCall, error) {
+	call := &RPCCall{Method: method, Result: resp}
+
+	val := reflect.Indirect(reflect.ValueOf(req))
+	if val.Kind() != reflect.Struct {
+		return nil, ErrStructPtrExpected
+	}
+
+	params := [][]int{}
+	for i := 0; i < val.NumField(); i++ {
+		sf := val.Type().Field(i)
+		if tag := sf.Tag.Get("jsonrpc"); tag != "" {
+			call.Method = tag
+			continue
+		}
+		if sf.PkgPath == "" || (sf.Anonymous && sf.Type.Kind() == reflect.Struct) {
+			params = append(params, []int{i})
+		}
+	}
+
+	if call.Method == "" {
+		return nil, errors.New("no json-rpc method, call through a command or tag the request")
+	}
+	if len(params) > 0 {
+		call.Params = subsetStruct(val, params, "")
+	}
+
+	return call, nil
+}
+
+func (t *JSONRPCTransport) request(call *RPCCall) *rpcRequest {
+	call.id = atomic.AddUint64(&t.lastID, 1)
+	return &rpcRequest{Version: "2.0", ID: call.id, Method: call.Method, Params: call.Params}
+}
+
+// post sends the body and decodes the reply
+func (t *JSONRPCTransport) post(ctx context.Context, body interface{}, reply interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create request: %w", err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	for name, values := range t.Header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return transportError(ctx, fmt.Errorf("unable to send request: %w", err))
+	}
+	defer httpResp.Body.Close()
+
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return transportError(ctx, fmt.Errorf("unable to read response: %w", err))
+	}
+
+	// services may report errors with an error status, prefer the rpc error when present
+	err = json.Unmarshal(data, reply)
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		if r, ok := reply.(*rpcResponse); ok && err == nil && r.Error != nil {
+			return nil
+		}
+		return &HTTPError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: data}
+	}
+	if err != nil {
+		return classify(ErrTransport, fmt.Errorf("unable to decode response: %w", err))
+	}
+
+	return nil
+}
+
+// complete applies the reply to the call, decoding the result or recording the error
+func (c *RPCCall) complete(reply rpcResponse) error {
+	switch {
+	case reply.Error != nil:
+		c.Err = reply.Error
+	case c.Result != nil && len(reply.Result) > 0:
+		err := json.Unmarshal(reply.Result, c.Result)
+		if err != nil {
+			c.Err = classify(ErrTransport, fmt.Errorf("unable to decode result: %w", err))
+		}
+	}
+
+	return c.Err
+}
+
+// RPCBatch collects calls to be sent in a single JSON-RPC batch request
+type RPCBatch struct {
+	transport *JSONRPCTransport
+	calls     []*RPCCall
+}
+
+// Add queues a call to method, the result is decoded into result once the batch is sent
+func (b *RPCBatch) Add(method string, params, result interface{}) *RPCCall {
+	call := &RPCCall{Method: method, Params: params, Result: result}
+	b.calls = append(b.calls, call)
+
+	return call
+}
+
+// AddCommand queues a call for the command, resolving the method and params as Handle does
+func (b *RPCBatch) AddCommand(cmd *Command, req, resp interface{}) (*RPCCall, error) {
+	call, err := b.transport.newCall(cmd.Name, req, resp)
+	if err != nil {
+		return nil, err
+	}
+	b.calls = append(b.calls, call)
+
+	return call, nil
+}
+
+// Calls returns the queued calls
+func (b *RPCBatch) Calls() []*RPCCall {
+	return b.calls
+}
+
+/*
+Do sends the queued calls as one batch request, the returned error covers the
+request as a whole, the outcome of each call is recorded in its Err field
+*/
+func (b *RPCBatch) Do(ctx context.Context) error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+
+	reqs := make([]*rpcRequest, len(b.calls))
+	byID := map[uint64]*RPCCall{}
+	for i, call := range b.calls {
+		reqs[i] = b.transport.request(call)
+		byID[call.id] = call
+	}
+
+	var replies []rpcResponse
+	err := b.transport.post(ctx, reqs, &replies)
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if call, ok := byID[reply.ID]; ok {
+			call.complete(reply)
+			delete(byID, reply.ID)
+		}
+	}
+	for _, call := range byID {
+		call.Err = errors.New("no response to call in batch")
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package combi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONRPCParamsEmbeddedStructs(t *testing.T) {
+	type listTasksRequest struct {
+		Filter string `json:"filter"`
+		paging
+	}
+
+	call, err := (&JSONRPCTransport{}).newCall("list_tasks", &listTasksRequest{Filter: "done", paging: paging{Limit: 5}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := json.Marshal(call.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(params) != `{"filter":"done","limit":5}` {
+		t.Errorf("params %s, want promoted paging fields", params)
+	}
+}
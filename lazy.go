@@ -0,0 +1,124 @@
+package combi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// lazyAnnotation marks the placeholder cobra commands created in lazy mode
+const lazyAnnotation = "combi.lazy"
+
+// Lazy reports whether static commands are registered lazily
+func (c *Commander) Lazy() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lazy
+}
+
+/*
+SetLazy enables lazy registration, commands added afterwards get a lightweight
+placeholder cobra command (name and description only) and are registered with
+their registration handler, inspecting the request and building flags, when
+first run or asked for help. Useful for CLIs with many commands to start fast
+*/
+func (c *Commander) SetLazy(lazy bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.lazy = lazy
+}
+
+// registerStatic registers the command with the cli, or a placeholder for it in lazy mode
+func (c *Commander) registerStatic(cmd *Command) error {
+	if !c.Lazy() || c.rootCmd == nil {
+		return cmd.Register(c.rootCmd)
+	}
+
+	name := cmd.Name
+	placeholder := &cobra.Command{
+		Use:                name,
+		Short:              cmd.ShortDesc,
+		Long:               cmd.LongDesc,
+		Annotations:        map[string]string{lazyAnnotation: "true"},
+		DisableFlagParsing: true,
+
+		// the built command runs the parent hooks, once its flags are parsed
+		PersistentPreRun:  func(*cobra.Command, []string) {},
+		PersistentPostRun: func(*cobra.Command, []string) {},
+
+		// the built command reports its own errors
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return c.runLazy(name, args)
+		},
+	}
+	placeholder.SetHelpFunc(func(_ *cobra.Command, args []string) {
+		static, err := c.materialise(name)
+		if err != nil {
+			c.handleStaticError(err)
+			return
+		}
+		static.HelpFunc()(static, args)
+	})
+	c.rootCmd.AddCommand(placeholder)
+
+	return nil
+}
+
+/*
+runLazy materialises the named command then executes the cli again with the
+same args, so cobra runs the built command as usual: parsing flags, validating
+args, running its hooks and subcommands
+*/
+func (c *Commander) runLazy(name string, args []string) error {
+	static, err := c.materialise(name)
+	if err != nil {
+		return err
+	}
+
+	// the path from the root to the built command, e.g. [get-tasks]
+	path := strings.Fields(static.CommandPath())[1:]
+	c.classifyUsageErrors()
+	root := static.Root()
+	root.SetArgs(append(path, args...))
+
+	return root.Execute()
+}
+
+/*
+materialise replaces the placeholder of the named command with the cobra
+command built by its registration handler, returning the registered command
+*/
+func (c *Commander) materialise(name string) (*cobra.Command, error) {
+	c.registry.Lock()
+	defer c.registry.Unlock()
+
+	c.RLock()
+	cmd, ok := c.commands[name]
+	c.RUnlock()
+	if !ok {
+		return nil, classify(ErrUsage, fmt.Errorf("unknown command: %s", name))
+	}
+
+	placeholders := []*cobra.Command{}
+	for _, static := range c.staticCommands(name) {
+		if static.Annotations[lazyAnnotation] == "" {
+			return static, nil
+		}
+		placeholders = append(placeholders, static)
+	}
+
+	err := cmd.Register(c.rootCmd)
+	if err != nil {
+		return nil, err
+	}
+	c.rootCmd.RemoveCommand(placeholders...)
+
+	for _, static := range c.staticCommands(name) {
+		return static, nil
+	}
+
+	return nil, fmt.Errorf("registration of %s did not add a command to the cli", name)
+}
@@ -0,0 +1,116 @@
+package combi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/chzyer/readline"
+	"gopkg.in/abiosoft/ishell.v2"
+)
+
+// defaultTerminalWidth is assumed for terminals when the width cannot be determined
+const defaultTerminalWidth = 80
+
+/*
+Output is the sink response handlers write to, along with some information on
+the terminal (if any) the output will be displayed on
+*/
+type Output struct {
+	io.Writer
+
+	// Terminal is true when the output is displayed on an interactive terminal
+	Terminal bool
+
+	// Width is the terminal width in columns, 0 when not writing to a terminal
+	Width int
+}
+
+// NewOutput returns an Output writing to w with no terminal
+func NewOutput(w io.Writer) *Output {
+	return &Output{Writer: w}
+}
+
+// StdoutOutput returns an Output writing to stdout, detecting whether stdout is a terminal
+func StdoutOutput() *Output {
+	out := &Output{Writer: os.Stdout}
+	if isTerminal(os.Stdout) {
+		out.Terminal = true
+		out.Width = terminalWidth()
+	}
+
+	return out
+}
+
+// ShellOutput returns an Output routing writes through the shell context
+func ShellOutput(sc *ishell.Context) *Output {
+	out := StdoutOutput()
+	out.Writer = shellWriter{sc}
+
+	return out
+}
+
+// shellWriter adapts an ishell context to io.Writer
+type shellWriter struct {
+	sc *ishell.Context
+}
+
+func (w shellWriter) Write(p []byte) (int, error) {
+	w.sc.Print(string(p))
+	return len(p), nil
+}
+
+/*
+OpenOutput applies the commander output settings (--out / --tee) to the base
+output, the returned close function must be called once the response has been
+handled
+*/
+func (c *Commander) OpenOutput(base *Output) (*Output, func() error, error) {
+	path, tee := c.OutputFile(), c.Tee()
+	if path == "" {
+		return base, func() error { return nil }, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open output file: %w", err)
+	}
+
+	// tee writes to both the file and the base output, keeping the terminal info
+	if tee {
+		return &Output{
+			Writer:   io.MultiWriter(f, base),
+			Terminal: base.Terminal,
+			Width:    base.Width,
+		}, f.Close, nil
+	}
+
+	return NewOutput(f), f.Close, nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+/*
+terminalWidth queries the width of the terminal, falling back to $COLUMNS and
+then a default when it cannot be determined
+*/
+func terminalWidth() int {
+	if width := readline.GetScreenWidth(); width > 0 {
+		return width
+	}
+
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		return defaultTerminalWidth
+	}
+
+	return width
+}
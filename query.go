@@ -0,0 +1,610 @@
+package combi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+Query is a compiled path expression used to select, filter and project parts of
+a response before it is formatted. The syntax is a subset of JMESPath evaluated
+directly against the response struct:
+
+	Tasks                          select a field (Go name, xml or json tag name, case insensitive)
+	Report.Results[0]              nested fields and indexes (negative indexes count from the end)
+	Tasks[*].Name                  project a field from every element of a list
+	Tasks[?Status=='Done']         filter a list (==, !=, <, <=, >, >=)
+	Tasks[*].{name: Name, s: Status} project elements into new objects
+	Tasks[*].[Name, Status]        project elements into lists
+
+Filtered lists keep their original element type so they can still be rendered
+by any formatter, projections produce generic lists and maps
+*/
+type Query struct {
+	expr  string
+	steps []queryStep
+}
+
+// CompileQuery parses a query expression
+func CompileQuery(expr string) (*Query, error) {
+	p := &queryParser{input: expr}
+	steps, err := p.parseSteps()
+	if err != nil {
+		return nil, classify(ErrUsage, fmt.Errorf("invalid query %q: %w", expr, err))
+	}
+	if !p.eof() {
+		return nil, classify(ErrUsage, fmt.Errorf("invalid query %q: unexpected %q at %d", expr, p.input[p.pos:], p.pos))
+	}
+
+	return &Query{expr: expr, steps: steps}, nil
+}
+
+// String returns the source expression
+func (q *Query) String() string {
+	return q.expr
+}
+
+// Apply evaluates the query against v, returning nil when nothing matches
+func (q *Query) Apply(v interface{}) (interface{}, error) {
+	res, err := evalSteps(reflect.ValueOf(v), q.steps)
+	if err != nil {
+		return nil, err
+	}
+
+	return valueInterface(res), nil
+}
+
+type stepKind int
+
+const (
+	stepField stepKind = iota
+	stepIndex
+	stepWildcard
+	stepFilter
+	stepHash
+	stepList
+)
+
+type queryStep struct {
+	kind  stepKind
+	name  string
+	index int
+
+	// filter comparison
+	lhs     []queryStep
+	op      string
+	literal string
+
+	// multiselect keys (hash only) and expressions
+	keys  []string
+	exprs [][]queryStep
+}
+
+// projects reports whether the step maps the remaining steps over a list
+func (s queryStep) projects() bool {
+	return s.kind == stepWildcard || s.kind == stepFilter
+}
+
+func evalSteps(val reflect.Value, steps []queryStep) (reflect.Value, error) {
+	for i, step := range steps {
+		val = indirect(val)
+		if !val.IsValid() {
+			return val, nil
+		}
+
+		// field access on a list projects over its elements
+		if step.kind == stepField && isList(val) {
+			return project(val, steps[i:])
+		}
+
+		if step.projects() {
+			if !isList(val) {
+				return reflect.Value{}, nil
+			}
+			list, err := filterList(val, step)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if i == len(steps)-1 {
+				return list, nil
+			}
+			return project(list, steps[i+1:])
+		}
+
+		var err error
+		val, err = evalStep(val, step)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	return val, nil
+}
+
+// project evaluates steps against every element of list, dropping empty results
+func project(list reflect.Value, steps []queryStep) (reflect.Value, error) {
+	results := []interface{}{}
+	for i := 0; i < list.Len(); i++ {
+		res, err := evalSteps(list.Index(i), steps)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if res = indirect(res); res.IsValid() {
+			results = append(results, valueInterface(res))
+		}
+	}
+
+	return reflect.ValueOf(results), nil
+}
+
+func evalStep(val reflect.Value, step queryStep) (reflect.Value, error) {
+	switch step.kind {
+	case stepField:
+		return lookupField(val, step.name), nil
+
+	case stepIndex:
+		if !isList(val) {
+			return reflect.Value{}, nil
+		}
+		i := step.index
+		if i < 0 {
+			i += val.Len()
+		}
+		if i < 0 || i >= val.Len() {
+			return reflect.Value{}, nil
+		}
+		return val.Index(i), nil
+
+	case stepHash:
+		res := map[string]interface{}{}
+		for i, expr := range step.exprs {
+			v, err := evalSteps(val, expr)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			res[step.keys[i]] = valueInterface(v)
+		}
+		return reflect.ValueOf(res), nil
+
+	case stepList:
+		res := []interface{}{}
+		for _, expr := range step.exprs {
+			v, err := evalSteps(val, expr)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			res = append(res, valueInterface(v))
+		}
+		return reflect.ValueOf(res), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("unexpected query step")
+}
+
+// filterList returns a new list of the same type holding the elements matching the step
+func filterList(list reflect.Value, step queryStep) (reflect.Value, error) {
+	sliceType := list.Type()
+	if list.Kind() == reflect.Array {
+		sliceType = reflect.SliceOf(sliceType.Elem())
+	}
+
+	res := reflect.MakeSlice(sliceType, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		elem := list.Index(i)
+		if step.kind == stepFilter {
+			lhs, err := evalSteps(elem, step.lhs)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if !compare(lhs, step.op, step.literal) {
+				continue
+			}
+		}
+		res = reflect.Append(res, elem)
+	}
+
+	return res, nil
+}
+
+// compare applies op to the value and literal, numerically when both parse as numbers
+func compare(val reflect.Value, op, literal string) bool {
+	val = indirect(val)
+	if !val.IsValid() {
+		return op == "!="
+	}
+	text := formatCell(val)
+
+	cmp := strings.Compare(text, literal)
+	a, errA := strconv.ParseFloat(text, 64)
+	b, errB := strconv.ParseFloat(literal, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+// lookupField finds a struct field or map key by Go name, xml or json tag name, ignoring case
+func lookupField(val reflect.Value, name string) reflect.Value {
+	switch val.Kind() {
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			if key.Kind() == reflect.String && strings.EqualFold(key.String(), name) {
+				return val.MapIndex(key)
+			}
+		}
+
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			sf := val.Type().Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			if strings.EqualFold(sf.Name, name) || strings.EqualFold(tagName(sf, "xml"), name) || strings.EqualFold(tagName(sf, "json"), name) {
+				return val.Field(i)
+			}
+		}
+	}
+
+	return reflect.Value{}
+}
+
+// tagName returns the name portion of a struct tag, ignoring paths and options
+func tagName(sf reflect.StructField, key string) string {
+	name := strings.Split(sf.Tag.Get(key), ",")[0]
+	if i := strings.LastIndex(name, ">"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+func indirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Value{}
+		}
+		val = val.Elem()
+	}
+
+	return val
+}
+
+func isList(val reflect.Value) bool {
+	return val.Kind() == reflect.Slice || val.Kind() == reflect.Array
+}
+
+func valueInterface(val reflect.Value) interface{} {
+	if !val.IsValid() || !val.CanInterface() {
+		return nil
+	}
+
+	return val.Interface()
+}
+
+// queryParser is a small recursive descent parser for query expressions
+type queryParser struct {
+	input string
+	pos   int
+}
+
+func (p *queryParser) eof() bool {
+	p.skipSpace()
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *queryParser) expect(s string) error {
+	p.skipSpace()
+	if !strings.HasPrefix(p.input[p.pos:], s) {
+		return fmt.Errorf("expected %q at %d", s, p.pos)
+	}
+	p.pos += len(s)
+
+	return nil
+}
+
+// parseSteps parses a dotted path, stopping at any character it does not recognise
+func (p *queryParser) parseSteps() ([]queryStep, error) {
+	steps := []queryStep{}
+	for {
+		switch c := p.peek(); {
+		case c == '[':
+			step, err := p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+		case c == '{' && len(steps) == 0:
+			step, err := p.parseHash()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+		case isIdentStart(c) && len(steps) == 0:
+			steps = append(steps, queryStep{kind: stepField, name: p.parseIdent()})
+		case c == '.' && len(steps) > 0:
+			p.pos++
+			switch p.peek() {
+			case '{':
+				step, err := p.parseHash()
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, step)
+			case '[':
+				step, err := p.parseList()
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, step)
+			default:
+				name := p.parseIdent()
+				if name == "" {
+					return nil, fmt.Errorf("expected field name at %d", p.pos)
+				}
+				steps = append(steps, queryStep{kind: stepField, name: name})
+			}
+		default:
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("expected expression at %d", p.pos)
+			}
+			return steps, nil
+		}
+	}
+}
+
+// parseBracket parses [n], [*], [?filter] or a multiselect list
+func (p *queryParser) parseBracket() (queryStep, error) {
+	start := p.pos
+	p.pos++
+
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		return queryStep{kind: stepWildcard}, p.expect("]")
+
+	case c == '?':
+		p.pos++
+		lhs, err := p.parseSteps()
+		if err != nil {
+			return queryStep{}, err
+		}
+		op, err := p.parseOp()
+		if err != nil {
+			return queryStep{}, err
+		}
+		literal, err := p.parseLiteral()
+		if err != nil {
+			return queryStep{}, err
+		}
+		return queryStep{kind: stepFilter, lhs: lhs, op: op, literal: literal}, p.expect("]")
+
+	case c == '-' || unicode.IsDigit(rune(c)):
+		begin := p.pos
+		p.pos++
+		for p.pos < len(p.input) && unicode.IsDigit(rune(p.input[p.pos])) {
+			p.pos++
+		}
+		index, err := strconv.Atoi(p.input[begin:p.pos])
+		if err != nil {
+			return queryStep{}, fmt.Errorf("invalid index at %d", begin)
+		}
+		return queryStep{kind: stepIndex, index: index}, p.expect("]")
+	}
+
+	p.pos = start
+	return p.parseList()
+}
+
+// parseList parses a multiselect list [expr, expr]
+func (p *queryParser) parseList() (queryStep, error) {
+	step := queryStep{kind: stepList}
+	err := p.expect("[")
+	if err != nil {
+		return step, err
+	}
+
+	for {
+		expr, err := p.parseSteps()
+		if err != nil {
+			return step, err
+		}
+		step.exprs = append(step.exprs, expr)
+
+		if p.peek() != ',' {
+			return step, p.expect("]")
+		}
+		p.pos++
+	}
+}
+
+// parseHash parses a multiselect hash {key: expr, key: expr}
+func (p *queryParser) parseHash() (queryStep, error) {
+	step := queryStep{kind: stepHash}
+	err := p.expect("{")
+	if err != nil {
+		return step, err
+	}
+
+	for {
+		p.skipSpace()
+		key := p.parseIdent()
+		if key == "" {
+			return step, fmt.Errorf("expected key at %d", p.pos)
+		}
+		err = p.expect(":")
+		if err != nil {
+			return step, err
+		}
+		expr, err := p.parseSteps()
+		if err != nil {
+			return step, err
+		}
+		step.keys = append(step.keys, key)
+		step.exprs = append(step.exprs, expr)
+
+		if p.peek() != ',' {
+			return step, p.expect("}")
+		}
+		p.pos++
+	}
+}
+
+func (p *queryParser) parseIdent() string {
+	p.skipSpace()
+	begin := p.pos
+	for p.pos < len(p.input) && (isIdentStart(p.input[p.pos]) || unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '-') {
+		p.pos++
+	}
+
+	return p.input[begin:p.pos]
+}
+
+func (p *queryParser) parseOp() (string, error) {
+	p.skipSpace()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op, nil
+		}
+	}
+
+	return "", fmt.Errorf("expected comparison operator at %d", p.pos)
+}
+
+// parseLiteral parses a 'raw string', `literal` or a bare number / word
+func (p *queryParser) parseLiteral() (string, error) {
+	switch c := p.peek(); c {
+	case '\'', '`', '"':
+		end := strings.IndexByte(p.input[p.pos+1:], c)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated literal at %d", p.pos)
+		}
+		literal := p.input[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return strings.Trim(literal, `"`), nil
+	}
+
+	begin := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(" ]", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if begin == p.pos {
+		return "", fmt.Errorf("expected literal at %d", p.pos)
+	}
+
+	return p.input[begin:p.pos], nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || unicode.IsLetter(rune(c))
+}
+
+/*
+xmlQueryResult wraps query results which encoding/xml cannot marshal directly
+(maps and lists of mixed values) so they can still be rendered as XML
+*/
+type xmlQueryResult struct {
+	value interface{}
+}
+
+// xmlValue returns resp unchanged when it is a struct, otherwise wraps it for generic XML encoding
+func xmlValue(resp interface{}) interface{} {
+	if indirect(reflect.ValueOf(resp)).Kind() == reflect.Struct {
+		return resp
+	}
+
+	return xmlQueryResult{value: resp}
+}
+
+// MarshalXML encodes the wrapped value under a <result> element
+func (r xmlQueryResult) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return encodeGenericXML(enc, "result", reflect.ValueOf(r.value))
+}
+
+func encodeGenericXML(enc *xml.Encoder, name string, val reflect.Value) error {
+	val = indirect(val)
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch val.Kind() {
+	case reflect.Invalid:
+		return enc.EncodeElement("", start)
+
+	case reflect.Struct:
+		return enc.EncodeElement(val.Interface(), start)
+
+	case reflect.Map:
+		err := enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return formatCell(keys[i]) < formatCell(keys[j])
+		})
+		for _, key := range keys {
+			err = encodeGenericXML(enc, formatCell(key), val.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+
+	case reflect.Slice, reflect.Array:
+		err := enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < val.Len(); i++ {
+			err = encodeGenericXML(enc, "item", val.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	}
+
+	return enc.EncodeElement(formatCell(val), start)
+}
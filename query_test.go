@@ -0,0 +1,163 @@
+package combi
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type queryTask struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name"`
+	Status   string `json:"state"`
+	Progress int    `xml:"progress"`
+}
+
+type queryResult struct {
+	Host  string `xml:"host"`
+	Score float64
+}
+
+type queryReport struct {
+	Results []queryResult `xml:"results>result"`
+}
+
+type queryResponse struct {
+	XMLName xml.Name     `xml:"get_tasks_response"`
+	Tasks   []*queryTask `xml:"task"`
+	Report  queryReport  `xml:"report"`
+}
+
+var (
+	weeklyTask = &queryTask{ID: "1", Name: "weekly scan", Status: "Done", Progress: 100}
+	dailyTask  = &queryTask{ID: "2", Name: "daily scan", Status: "Running", Progress: 40}
+	adhocTask  = &queryTask{ID: "3", Name: "adhoc", Status: "Done", Progress: 9}
+
+	queryResp = &queryResponse{
+		Tasks: []*queryTask{weeklyTask, dailyTask, adhocTask},
+		Report: queryReport{Results: []queryResult{
+			{Host: "10.0.0.1", Score: 7.5},
+			{Host: "10.0.0.2", Score: 2},
+		}},
+	}
+)
+
+func TestQueryApply(t *testing.T) {
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		// field names, xml and json tag names, in any case
+		{"Tasks[0].Name", "weekly scan"},
+		{"tasks[0].NAME", "weekly scan"},
+		{"task[1].id", "2"},
+		{"Tasks[0].state", "Done"},
+		{"report.result[0].host", "10.0.0.1"},
+		{"Report.Results[1].Score", float64(2)},
+		{"Tasks[0].Bogus", nil},
+
+		// indexes
+		{"Tasks[2].Name", "adhoc"},
+		{"Tasks[-1].Name", "adhoc"},
+		{"Tasks[-3].Name", "weekly scan"},
+		{"Tasks[3]", nil},
+		{"Tasks[-4]", nil},
+		{"Report[0]", nil},
+
+		// projections over every element
+		{"Tasks[*].Name", []interface{}{"weekly scan", "daily scan", "adhoc"}},
+		{"Tasks.Name", []interface{}{"weekly scan", "daily scan", "adhoc"}},
+		{"Report.Results[*].Host", []interface{}{"10.0.0.1", "10.0.0.2"}},
+		{"Tasks[*]", []*queryTask{weeklyTask, dailyTask, adhocTask}},
+
+		// filters compare numerically when both sides are numbers
+		{"Tasks[?Progress == `40`].Name", []interface{}{"daily scan"}},
+		{"Tasks[?Progress != 40].Name", []interface{}{"weekly scan", "adhoc"}},
+		{"Tasks[?Progress < 40].Name", []interface{}{"adhoc"}},
+		{"Tasks[?Progress <= 40].Name", []interface{}{"daily scan", "adhoc"}},
+		{"Tasks[?Progress > 10].Name", []interface{}{"weekly scan", "daily scan"}},
+		{"Tasks[?Progress >= 40].Name", []interface{}{"weekly scan", "daily scan"}},
+		{"Report.Results[?Score > 2.5].Host", []interface{}{"10.0.0.1"}},
+
+		// and as strings otherwise
+		{"Tasks[?Status=='Done'].Name", []interface{}{"weekly scan", "adhoc"}},
+		{`Tasks[?Status!="Done"].Name`, []interface{}{"daily scan"}},
+		{"Tasks[?Name<'d'].Name", []interface{}{"adhoc"}},
+		{"Tasks[?Name<='daily scan'].Name", []interface{}{"daily scan", "adhoc"}},
+		{"Tasks[?Name>'d'].Name", []interface{}{"weekly scan", "daily scan"}},
+		{"Tasks[?Name>=weekly].Name", []interface{}{"weekly scan"}},
+		{"Tasks[?Status=='Paused'].Name", []interface{}{}},
+
+		// filtered lists keep their element type
+		{"Tasks[?Status=='Done']", []*queryTask{weeklyTask, adhocTask}},
+
+		// hash and list projections
+		{"Tasks[*].{name: Name, s: Status}", []interface{}{
+			map[string]interface{}{"name": "weekly scan", "s": "Done"},
+			map[string]interface{}{"name": "daily scan", "s": "Running"},
+			map[string]interface{}{"name": "adhoc", "s": "Done"},
+		}},
+		{"Tasks[*].[Name, Progress]", []interface{}{
+			[]interface{}{"weekly scan", 100},
+			[]interface{}{"daily scan", 40},
+			[]interface{}{"adhoc", 9},
+		}},
+		{"Tasks[?Progress < 40].{id: ID}", []interface{}{map[string]interface{}{"id": "3"}}},
+		{"{first: Tasks[0].Name, hosts: Report.Results[*].Host}", map[string]interface{}{
+			"first": "weekly scan",
+			"hosts": []interface{}{"10.0.0.1", "10.0.0.2"},
+		}},
+	}
+
+	for _, test := range tests {
+		q, err := CompileQuery(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		got, err := q.Apply(queryResp)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "expected expression at 0"},
+		{"Tasks[", "expected expression at 6"},
+		{"Tasks[0", `expected "]" at 7`},
+		{"Tasks[-x]", "invalid index at 6"},
+		{"Tasks.", "expected field name at 6"},
+		{"Tasks[0]]", `unexpected "]" at 8`},
+		{"Tasks  ]", `unexpected "]" at 7`},
+		{"Tasks[?Status~'x']", "expected comparison operator at 13"},
+		{"Tasks[?Status==]", "expected literal at 15"},
+		{"Tasks[?Status=='x", "unterminated literal at 15"},
+		{"{: Name}", "expected key at 1"},
+		{"Tasks[*].{name Name}", `expected ":" at 15`},
+	}
+
+	for _, test := range tests {
+		_, err := CompileQuery(test.expr)
+		if err == nil {
+			t.Errorf("%q: no error", test.expr)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("%q: error %q, want %q", test.expr, err, test.want)
+		}
+		if !errors.Is(err, ErrUsage) {
+			t.Errorf("%q: error %v, want ErrUsage", test.expr, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package combi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/abiosoft/ishell.v2"
+)
+
+// RegistryEventType identifies the kind of change made to the command registry
+type RegistryEventType string
+
+// Registry event types
+const (
+	CommandAdded    RegistryEventType = "added"
+	CommandRemoved  RegistryEventType = "removed"
+	CommandReplaced RegistryEventType = "replaced"
+)
+
+// RegistryEvent describes a change made to the command registry
+type RegistryEvent struct {
+	Type RegistryEventType
+	Name string
+
+	// Command is the registered command, nil when removed
+	Command *Command
+
+	// Previous is the removed or replaced command, nil when added
+	Previous *Command
+}
+
+// RegistryListener is called after each change to the command registry
+type RegistryListener func(e RegistryEvent)
+
+/*
+OnRegistryChange adds a listener called after commands are added, removed or
+replaced. Shells registered with RegisterShell are kept in sync without one
+*/
+func (c *Commander) OnRegistryChange(l RegistryListener) {
+	c.Lock()
+	defer c.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// notify calls the registry listeners, outside of any lock so listeners may change the registry
+func (c *Commander) notify(e RegistryEvent) {
+	c.RLock()
+	listeners := c.listeners
+	c.RUnlock()
+
+	for _, l := range listeners {
+		l(e)
+	}
+}
+
+// Lookup returns a copy of the named command, changes to the copy do not affect the registry
+func (c *Commander) Lookup(name string) (Command, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	cmd, ok := c.commands[name]
+	if !ok {
+		return Command{}, false
+	}
+
+	return *cmd, true
+}
+
+// Names returns the sorted names of all registered commands
+func (c *Commander) Names() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// add registers a single command, failing if the name is taken
+func (c *Commander) add(cmd *Command) error {
+	c.registry.Lock()
+
+	c.RLock()
+	_, exists := c.commands[cmd.Name]
+	c.RUnlock()
+	if exists {
+		c.registry.Unlock()
+		return classify(ErrUsage, fmt.Errorf("command already registered: %s", cmd.Name))
+	}
+
+	cmd.Commander = c
+	err := c.registerStatic(cmd)
+	if err != nil {
+		c.registry.Unlock()
+		return err
+	}
+
+	c.Lock()
+	c.commands[cmd.Name] = cmd
+	shells := append([]*ishell.Shell{}, c.shells...)
+	c.Unlock()
+
+	for _, shell := range shells {
+		cmd.RegisterToShell(shell)
+	}
+	c.registry.Unlock()
+
+	c.notify(RegistryEvent{Type: CommandAdded, Name: cmd.Name, Command: cmd})
+
+	return nil
+}
+
+// Remove deregisters the named command from the commander, the cli and any registered shells
+func (c *Commander) Remove(name string) error {
+	c.registry.Lock()
+
+	c.Lock()
+	prev, ok := c.commands[name]
+	if !ok {
+		c.Unlock()
+		c.registry.Unlock()
+		return classify(ErrUsage, fmt.Errorf("unknown command: %s", name))
+	}
+	delete(c.commands, name)
+	shells := append([]*ishell.Shell{}, c.shells...)
+	c.Unlock()
+
+	c.removeStatic(name)
+	for _, shell := range shells {
+		shell.DeleteCmd(name)
+	}
+	c.registry.Unlock()
+
+	c.notify(RegistryEvent{Type: CommandRemoved, Name: name, Previous: prev})
+
+	return nil
+}
+
+/*
+Replace swaps the registered command of the same name for cmd, in the cli and
+any registered shells. The existing command is kept if cmd fails to register
+*/
+func (c *Commander) Replace(cmd *Command) error {
+	c.registry.Lock()
+
+	c.RLock()
+	prev, ok := c.commands[cmd.Name]
+	c.RUnlock()
+	if !ok {
+		c.registry.Unlock()
+		return classify(ErrUsage, fmt.Errorf("unknown command: %s", cmd.Name))
+	}
+
+	// register the new static command before removing the old one
+	stale := c.staticCommands(cmd.Name)
+	cmd.Commander = c
+	err := c.registerStatic(cmd)
+	if err != nil {
+		c.registry.Unlock()
+		return err
+	}
+	if c.rootCmd != nil {
+		c.rootCmd.RemoveCommand(stale...)
+	}
+
+	c.Lock()
+	c.commands[cmd.Name] = cmd
+	shells := append([]*ishell.Shell{}, c.shells...)
+	c.Unlock()
+
+	// shells replace commands of the same name
+	for _, shell := range shells {
+		cmd.RegisterToShell(shell)
+	}
+	c.registry.Unlock()
+
+	c.notify(RegistryEvent{Type: CommandReplaced, Name: cmd.Name, Command: cmd, Previous: prev})
+
+	return nil
+}
+
+// staticCommands returns the cobra commands registered under name
+func (c *Commander) staticCommands(name string) []*cobra.Command {
+	if c.rootCmd == nil {
+		return nil
+	}
+
+	res := []*cobra.Command{}
+	for _, sc := range c.rootCmd.Commands() {
+		if sc.Name() == name {
+			res = append(res, sc)
+		}
+	}
+
+	return res
+}
+
+// removeStatic removes the cobra commands registered under name
+func (c *Commander) removeStatic(name string) {
+	if stale := c.staticCommands(name); len(stale) > 0 {
+		c.rootCmd.RemoveCommand(stale...)
+	}
+}
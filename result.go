@@ -0,0 +1,57 @@
+package combi
+
+import (
+	"context"
+	"time"
+)
+
+// Mode is the way a command was invoked
+type Mode string
+
+// Command invocation modes
+const (
+	ModeStatic Mode = "static"
+	ModeShell  Mode = "shell"
+)
+
+// Result describes a completed command execution, passed to post, error and success hooks
+type Result struct {
+	// Command is the executed command
+	Command *Command
+
+	// Mode is static for cli invocations, shell for shell invocations
+	Mode Mode
+
+	// Args holds the positional arguments the command was invoked with
+	Args []string
+
+	// Start is the time execution started, before pre hooks were run
+	Start time.Time
+
+	// Duration is the time taken to execute the command
+	Duration time.Duration
+
+	// Err is the error returned by the exec handler or an *AbortError from a pre hook, nil on success
+	Err error
+
+	// Skipped is true when a pre hook skipped the command with ErrSkip
+	Skipped bool
+
+	// ShortCircuited is true when a pre hook presented a response in place of executing the command
+	ShortCircuited bool
+}
+
+// Success reports whether the execution completed without error
+func (r *Result) Success() bool {
+	return r.Err == nil
+}
+
+// ResultHook provides a hook signature for hooks run after a command has executed
+type ResultHook func(ctx context.Context, r *Result) error
+
+// AdaptPostHook converts a CommandHook to a ResultHook so it can be used as a post hook
+func AdaptPostHook(f CommandHook) ResultHook {
+	return func(ctx context.Context, r *Result) error {
+		return f(ctx, r.Command)
+	}
+}
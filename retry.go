@@ -0,0 +1,219 @@
+package combi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+RetryPolicy configures the Retry middleware. Failed requests are retried while
+the error is retryable and attempts remain, waiting an exponentially increasing
+backoff (with jitter) between attempts. The response is reset before each
+retry and the whole sequence of attempts shares the command timeout
+*/
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first, 1 or less disables retries
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts, no cap when zero
+	MaxBackoff time.Duration
+
+	// Multiplier grows the backoff after each attempt, 2 when zero
+	Multiplier float64
+
+	// Jitter randomises each wait by up to this fraction (0 - 1) either way
+	Jitter float64
+
+	// Retryable classifies errors, DefaultRetryable when nil
+	Retryable func(err error) bool
+
+	// Idempotent reports whether a command is safe to retry, DefaultIdempotent when nil
+	Idempotent func(c *Command) bool
+}
+
+// DefaultRetryPolicy makes up to 3 attempts, backing off from 200ms
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// NoRetry can be set as a command Retry policy to disable retries for that command
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// RetryAttempt describes a failed attempt, passed to retry hooks
+type RetryAttempt struct {
+	// Command is the executing command, nil when the handler was called outside of a command
+	Command *Command
+
+	// Attempt is the number of the failed attempt, starting at 1
+	Attempt int
+
+	// Err is the error returned by the attempt
+	Err error
+
+	// Retrying is false when this was the last attempt made
+	Retrying bool
+
+	// Delay is the wait before the next attempt
+	Delay time.Duration
+}
+
+// RetryHook is called by the Retry middleware after each failed attempt
+type RetryHook func(ctx context.Context, attempt RetryAttempt)
+
+/*
+Retry returns a middleware retrying the wrapped RequestHandler according to the
+policy. When called through a command, the command Retry policy (if set) is
+used instead and the commander retry hooks are called after each failed attempt
+*/
+func Retry(policy RetryPolicy) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, req, resp interface{}) error {
+			p := policy
+			cmd, ok := CommandFromContext(ctx)
+			if ok && cmd.Retry != nil {
+				p = *cmd.Retry
+			}
+
+			maxAttempts := p.MaxAttempts
+			if ok && !p.idempotent(cmd) {
+				maxAttempts = 1
+			}
+
+			var hooks []RetryHook
+			if ok {
+				hooks = cmd.Commander.RetryHooks()
+			}
+
+			for attempt := 1; ; attempt++ {
+				if attempt > 1 {
+					resetResponse(resp)
+				}
+
+				err := next(ctx, req, resp)
+				if err == nil {
+					return nil
+				}
+
+				retrying := attempt < maxAttempts && ctx.Err() == nil && p.retryable(err)
+				delay := time.Duration(0)
+				if retrying {
+					delay = p.backoff(attempt)
+				}
+
+				for _, hook := range hooks {
+					hook(ctx, RetryAttempt{Command: cmd, Attempt: attempt, Err: err, Retrying: retrying, Delay: delay})
+				}
+
+				if !retrying {
+					return err
+				}
+
+				// give up waiting when the context is done, the caller reports the timeout
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				}
+			}
+		}
+	}
+}
+
+// resetResponse zeroes the response so a retry does not decode over what a failed attempt left
+func resetResponse(resp interface{}) {
+	val := reflect.ValueOf(resp)
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		val.Elem().Set(reflect.Zero(val.Elem().Type()))
+	}
+}
+
+// backoff returns the wait following the given attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return DefaultRetryable(err)
+}
+
+func (p RetryPolicy) idempotent(c *Command) bool {
+	if p.Idempotent != nil {
+		return p.Idempotent(c)
+	}
+
+	return DefaultIdempotent(c)
+}
+
+// DefaultIdempotent treats all commands as safe to retry except create-* commands
+func DefaultIdempotent(c *Command) bool {
+	return !strings.HasPrefix(c.Name, "create-")
+}
+
+/*
+DefaultRetryable reports whether an error is likely to be transient: network
+and connection errors, HTTP 429 / 502 / 503 / 504 replies and OMP 503 (service
+temporarily down) statuses. Cancellation and timeouts of the context itself are
+never retried
+*/
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusServiceUnavailable
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
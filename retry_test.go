@@ -0,0 +1,188 @@
+package combi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type retryResponse struct {
+	Tasks []string
+	Error string
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second} {
+		if got := p.backoff(attempt + 1); got != want {
+			t.Errorf("attempt %d: backoff %s, want %s", attempt+1, got, want)
+		}
+	}
+
+	// the multiplier defaults to 2
+	p = RetryPolicy{InitialBackoff: 100 * time.Millisecond}
+	if got := p.backoff(3); got != 400*time.Millisecond {
+		t.Errorf("backoff %s with the default multiplier, want 400ms", got)
+	}
+
+	p = RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff %s, want within 50%% of 100ms", got)
+		}
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&HTTPError{StatusCode: 429}, true},
+		{&HTTPError{StatusCode: 502}, true},
+		{&HTTPError{StatusCode: 503}, true},
+		{&HTTPError{StatusCode: 504}, true},
+		{&HTTPError{StatusCode: 404}, false},
+		{&HTTPError{StatusCode: 500}, false},
+		{&StatusError{Code: 503}, true},
+		{&StatusError{Code: 400}, false},
+		{fmt.Errorf("reading reply: %w", io.EOF), true},
+		{io.ErrUnexpectedEOF, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.DNSError{Err: "no such host"}, true},
+		{context.Canceled, false},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), false},
+		{errors.New("invalid task id"), false},
+	}
+
+	for _, test := range tests {
+		if got := DefaultRetryable(test.err); got != test.want {
+			t.Errorf("%#v: retryable %t, want %t", test.err, got, test.want)
+		}
+	}
+}
+
+/*
+newRetryCommand returns a command retried by policy whose handler fails with
+fail for the first failures attempts, each attempt appends a task to the
+response and failures set its error field, as error bodies decoded into the
+response would
+*/
+func newRetryCommand(t *testing.T, name string, policy RetryPolicy, failures int, fail error) (*Command, *int) {
+	c := NewCommander(&cobra.Command{Use: "app"})
+	c.Use(Retry(policy))
+
+	attempts := 0
+	cmd := &Command{
+		Name:     name,
+		Request:  &executeRequest{},
+		Response: &retryResponse{},
+		RequestHandler: func(ctx context.Context, req, resp interface{}) error {
+			attempts++
+			r := resp.(*retryResponse)
+			r.Tasks = append(r.Tasks, fmt.Sprintf("attempt %d", attempts))
+			if attempts <= failures {
+				r.Error = "service unavailable"
+				return fail
+			}
+			return nil
+		},
+	}
+	if err := c.Add(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd, &attempts
+}
+
+var quickRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+func TestRetryResetsResponse(t *testing.T) {
+	cmd, attempts := newRetryCommand(t, "get-tasks", quickRetry, 2, &HTTPError{StatusCode: 503})
+
+	resp := &retryResponse{}
+	if err := cmd.HandleRequest(context.Background(), &executeRequest{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if *attempts != 3 {
+		t.Errorf("%d attempts, want 3", *attempts)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0] != "attempt 3" || resp.Error != "" {
+		t.Errorf("response %+v, want only the last attempt", resp)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	cmd, attempts := newRetryCommand(t, "get-tasks", quickRetry, 5, &HTTPError{StatusCode: 503})
+	err := cmd.HandleRequest(context.Background(), &executeRequest{}, &retryResponse{})
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Errorf("error %v, want the last *HTTPError", err)
+	}
+	if *attempts != 3 {
+		t.Errorf("%d attempts, want MaxAttempts", *attempts)
+	}
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	cmd, attempts := newRetryCommand(t, "get-tasks", quickRetry, 5, &HTTPError{StatusCode: 404})
+	if err := cmd.HandleRequest(context.Background(), &executeRequest{}, &retryResponse{}); err == nil {
+		t.Fatal("no error")
+	}
+	if *attempts != 1 {
+		t.Errorf("%d attempts, want 404 replies not retried", *attempts)
+	}
+}
+
+func TestRetryOverrides(t *testing.T) {
+	tests := []struct {
+		desc   string
+		name   string
+		policy RetryPolicy
+		retry  *RetryPolicy
+		want   int
+	}{
+		{"create commands", "create-task", quickRetry, nil, 1},
+		{"Idempotent", "create-task", RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Idempotent: func(*Command) bool { return true }}, nil, 3},
+		{"NoRetry", "get-tasks", quickRetry, NoRetry, 1},
+		{"command policy", "get-tasks", quickRetry, &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}, 2},
+		{"Retryable", "get-tasks", RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Retryable: func(error) bool { return false }}, nil, 1},
+	}
+
+	for _, test := range tests {
+		cmd, attempts := newRetryCommand(t, test.name, test.policy, 5, &HTTPError{StatusCode: 503})
+		cmd.Retry = test.retry
+		cmd.HandleRequest(context.Background(), &executeRequest{}, &retryResponse{})
+		if *attempts != test.want {
+			t.Errorf("%s: %d attempts, want %d", test.desc, *attempts, test.want)
+		}
+	}
+}
+
+func TestRetryHooks(t *testing.T) {
+	cmd, _ := newRetryCommand(t, "get-tasks", quickRetry, 5, &HTTPError{StatusCode: 503})
+
+	var seen []RetryAttempt
+	cmd.Commander.AddRetryHooks(func(ctx context.Context, attempt RetryAttempt) {
+		seen = append(seen, attempt)
+	})
+	cmd.HandleRequest(context.Background(), &executeRequest{}, &retryResponse{})
+
+	if len(seen) != 3 {
+		t.Fatalf("%d hook calls, want one per attempt", len(seen))
+	}
+	for i, attempt := range seen {
+		if attempt.Command != cmd || attempt.Attempt != i+1 || attempt.Retrying != (i < 2) {
+			t.Errorf("hook call %d: %+v", i, attempt)
+		}
+	}
+}
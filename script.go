@@ -0,0 +1,343 @@
+package combi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/abiosoft/ishell.v2"
+)
+
+// ScriptOptions configures RunScript
+type ScriptOptions struct {
+	// ContinueOnError runs the remaining commands after a failure, rather than stopping
+	ContinueOnError bool
+}
+
+// ScriptError is returned by RunScript when commands failed, it unwraps to the first failure
+type ScriptError struct {
+	// Commands is the number of commands run
+	Commands int
+
+	// Failed is the number of commands which failed
+	Failed int
+
+	// Err is the first failure
+	Err error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%d of %d script commands failed, first: %s", e.Failed, e.Commands, e.Err)
+}
+
+// Unwrap returns the first failure, so the exit code follows its class
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// Scripting reports whether the commander is running a script, when shell commands must not prompt
+func (c *Commander) Scripting() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.scripting
+}
+
+func (c *Commander) setScripting(scripting bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.scripting = scripting
+}
+
+/*
+RunScript runs shell commands read line by line from r without interaction.
+Blank lines and lines starting with # are ignored, arguments may be quoted and
+prompt answers are given as inline flags (create-task --name "weekly scan"),
+missing required fields fail the command and an exit line ends the script.
+Failures are reported to stderr with their line number, the script stops at the
+first unless ContinueOnError is set and a *ScriptError summarising the failures
+is returned. Sessions are closed before it returns.
+
+Commands between batch and end lines have their JSON-RPC calls sent together,
+one batch request per JSONRPCTransport, when the end line (or the end of the
+script) is reached. Their responses are then presented in script order
+*/
+func (c *Commander) RunScript(r io.Reader, opts ScriptOptions) error {
+
+	// read the whole script first, the shell reads stdin once created
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read script: %w", err)
+	}
+
+	shell, err := c.NewShell()
+	if err != nil {
+		return err
+	}
+	defer shell.Close()
+
+	// unknown commands fail the script rather than printing help
+	shell.NotFound(func(sc *ishell.Context) {
+		c.HandleShellError(sc, classify(ErrUsage, fmt.Errorf("unknown command: %s", strings.Join(sc.Args, " "))))
+	})
+
+	// exit ends the script, sessions are closed once it ends
+	exited := false
+	shell.AddCmd(&ishell.Cmd{
+		Name: "exit",
+		Help: "end the script",
+		Func: func(sc *ishell.Context) {
+			exited = true
+		},
+	})
+
+	c.setScripting(true)
+	defer c.setScripting(false)
+
+	res := &ScriptError{}
+	stopped := false
+	fail := func(line int, err error) {
+		res.Failed++
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", line, err)
+		if res.Err == nil {
+			res.Err = fmt.Errorf("line %d: %w", line, err)
+		}
+		stopped = !opts.ContinueOnError
+	}
+
+	var batch *scriptBatch
+	for i := 0; i < len(lines) && !exited && !stopped; i++ {
+		args, err := splitScriptLine(lines[i])
+		if err == nil && len(args) == 0 {
+			continue
+		}
+
+		// batch blocks
+		if err == nil && len(args) == 1 && (args[0] == "batch" || args[0] == "end") {
+			switch {
+			case args[0] == "batch" && batch != nil:
+				fail(i+1, classify(ErrUsage, errors.New("batch already started")))
+			case args[0] == "batch":
+				batch = c.startScriptBatch()
+			case batch == nil:
+				fail(i+1, classify(ErrUsage, errors.New("end without batch")))
+			default:
+				c.sendScriptBatch(batch, fail)
+				batch = nil
+			}
+			continue
+		}
+
+		res.Commands++
+		if err == nil && batch != nil {
+			err = batch.run(shell, i+1, args)
+		} else if err == nil {
+			err = shell.Process(args...)
+		}
+		if err != nil {
+			fail(i+1, err)
+		}
+	}
+
+	// an unfinished batch is sent when the script ends
+	if batch != nil {
+		c.sendScriptBatch(batch, fail)
+	}
+
+	closeErr := c.Close()
+	if res.Failed > 0 {
+		return res
+	}
+
+	return closeErr
+}
+
+/*
+scriptBatch collects the JSON-RPC calls of the commands in a script batch
+block. Each command runs until its call is queued, then waits for the batch to
+be sent before presenting its response
+*/
+type scriptBatch struct {
+	batches map[*JSONRPCTransport]*RPCBatch
+	order   []*JSONRPCTransport
+	errs    map[*JSONRPCTransport]error
+	pending []*batchedCommand
+
+	// queued receives the calls of running commands, sent is closed once the batch is sent
+	queued chan *batchedCall
+	sent   chan struct{}
+}
+
+// batchedCall is a JSON-RPC call queued in a script batch
+type batchedCall struct {
+	batch     *scriptBatch
+	transport *JSONRPCTransport
+	call      *RPCCall
+	release   chan struct{}
+}
+
+// batchedCommand is a script command waiting on its queued call
+type batchedCommand struct {
+	line int
+	call *batchedCall
+	done chan error
+}
+
+// scriptBatch returns the open script batch, nil outside of batch blocks
+func (c *Commander) scriptBatch() *scriptBatch {
+	c.RLock()
+	defer c.RUnlock()
+	return c.batch
+}
+
+func (c *Commander) startScriptBatch() *scriptBatch {
+	b := &scriptBatch{
+		batches: map[*JSONRPCTransport]*RPCBatch{},
+		errs:    map[*JSONRPCTransport]error{},
+		queued:  make(chan *batchedCall),
+		sent:    make(chan struct{}),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.batch = b
+
+	return b
+}
+
+/*
+run runs the command until it completes or its JSON-RPC call is queued, in
+which case it is left waiting for the batch to be sent
+*/
+func (b *scriptBatch) run(shell *ishell.Shell, line int, args []string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- shell.Process(args...)
+	}()
+
+	select {
+	case bc := <-b.queued:
+		rb, ok := b.batches[bc.transport]
+		if !ok {
+			rb = bc.transport.NewBatch()
+			b.batches[bc.transport] = rb
+			b.order = append(b.order, bc.transport)
+		}
+		rb.calls = append(rb.calls, bc.call)
+		b.pending = append(b.pending, &batchedCommand{line: line, call: bc, done: done})
+		return nil
+	case err := <-done:
+		return err
+	}
+}
+
+/*
+sendScriptBatch sends the queued calls, one request per transport, then lets
+the waiting commands finish in script order, reporting their failures with
+fail. Calls made after the batch is sent are sent on their own
+*/
+func (c *Commander) sendScriptBatch(b *scriptBatch, fail func(line int, err error)) {
+	c.Lock()
+	c.batch = nil
+	c.Unlock()
+	close(b.sent)
+
+	for _, t := range b.order {
+		if err := b.batches[t].Do(c.Context()); err != nil {
+			b.errs[t] = err
+		}
+	}
+
+	for _, cmd := range b.pending {
+		close(cmd.call.release)
+		if err := <-cmd.done; err != nil {
+			fail(cmd.line, err)
+		}
+	}
+}
+
+// queue adds the call to the batch, nil when the batch has already been sent
+func (b *scriptBatch) queue(t *JSONRPCTransport, call *RPCCall) *batchedCall {
+	bc := &batchedCall{batch: b, transport: t, call: call, release: make(chan struct{})}
+
+	select {
+	case b.queued <- bc:
+		return bc
+	case <-b.sent:
+		return nil
+	}
+}
+
+// wait waits for the batch to be sent, returning the outcome of the call
+func (bc *batchedCall) wait(ctx context.Context) error {
+	select {
+	case <-bc.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := bc.batch.errs[bc.transport]; err != nil {
+		return err
+	}
+
+	return bc.call.Err
+}
+
+// splitScriptLine splits a script line into arguments, honouring quotes and backslash escapes
+func splitScriptLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, classify(ErrUsage, errors.New("unterminated quote or escape"))
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,147 @@
+package combi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type scriptTaskRequest struct {
+	Method struct{} `jsonrpc:"tasks.get"`
+	ID     string   `json:"id" lFlag:"id" hint:"task id"`
+}
+
+type scriptTaskResponse struct {
+	Name string `json:"name"`
+}
+
+// rpcRecorder is a JSON-RPC service recording the calls of each request it receives
+type rpcRecorder struct {
+	sync.Mutex
+	requests [][]string
+}
+
+func (r *rpcRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batch := strings.HasPrefix(string(body), "[")
+	calls := []map[string]interface{}{}
+	if batch {
+		json.Unmarshal(body, &calls)
+	} else {
+		call := map[string]interface{}{}
+		json.Unmarshal(body, &call)
+		calls = append(calls, call)
+	}
+
+	ids := []string{}
+	replies := []map[string]interface{}{}
+	for _, call := range calls {
+		id := call["params"].(map[string]interface{})["id"].(string)
+		ids = append(ids, id)
+
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": call["id"]}
+		if id == "missing" {
+			reply["error"] = map[string]interface{}{"code": 404, "message": "no such task"}
+		} else {
+			reply["result"] = map[string]interface{}{"name": "task " + id}
+		}
+		replies = append(replies, reply)
+	}
+
+	r.Lock()
+	r.requests = append(r.requests, ids)
+	r.Unlock()
+
+	if batch {
+		json.NewEncoder(w).Encode(replies)
+	} else {
+		json.NewEncoder(w).Encode(replies[0])
+	}
+}
+
+func runRPCScript(t *testing.T, script string, opts ScriptOptions) (error, [][]string) {
+	rec := &rpcRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := NewCommander(&cobra.Command{Use: "app"})
+	if err := c.SetOutputFile(filepath.Join(t.TempDir(), "out.json")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetErrorHandler(func(err error) {})
+
+	transport := &JSONRPCTransport{URL: srv.URL}
+	err := c.Add(&Command{
+		Name:           "get-task",
+		Request:        &scriptTaskRequest{},
+		Response:       &scriptTaskResponse{},
+		RequestHandler: transport.RequestHandler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.RunScript(strings.NewReader(script), opts)
+
+	rec.Lock()
+	defer rec.Unlock()
+	return err, rec.requests
+}
+
+func TestRunScriptBatchesCalls(t *testing.T) {
+	err, requests := runRPCScript(t, "batch\nget-task --id 1\nget-task --id 2\nend\nget-task --id 3\n", ScriptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 || strings.Join(requests[0], ",") != "1,2" || strings.Join(requests[1], ",") != "3" {
+		t.Errorf("requests %v, want [[1 2] [3]]", requests)
+	}
+}
+
+func TestRunScriptBatchSentAtEnd(t *testing.T) {
+	err, requests := runRPCScript(t, "batch\nget-task --id 1\nget-task --id 2\n", ScriptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 1 || len(requests[0]) != 2 {
+		t.Errorf("requests %v, want one batch of two calls", requests)
+	}
+}
+
+func TestRunScriptBatchFailures(t *testing.T) {
+	err, requests := runRPCScript(t, "batch\nget-task --id missing\nget-task --id 2\nend\nget-task --id 3\n", ScriptOptions{})
+
+	scriptErr, ok := err.(*ScriptError)
+	if !ok {
+		t.Fatalf("error %v, want a *ScriptError", err)
+	}
+	if scriptErr.Commands != 2 || scriptErr.Failed != 1 || !strings.HasPrefix(scriptErr.Err.Error(), "line 2: ") {
+		t.Errorf("%d commands, %d failed, first %v, want the batch to fail at line 2 and the script to stop", scriptErr.Commands, scriptErr.Failed, scriptErr.Err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("requests %v, want only the batch sent", requests)
+	}
+}
+
+func TestRunScriptBatchDirectives(t *testing.T) {
+	for _, script := range []string{"end\n", "batch\nbatch\n"} {
+		err, _ := runRPCScript(t, script, ScriptOptions{})
+		if !errors.Is(err, ErrUsage) {
+			t.Errorf("%q: error %v, want ErrUsage", script, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package combi
+
+import (
+	"io"
+	"sync"
+)
+
+/*
+Sessions keeps long lived connections (or any other closeable session) alive
+between command invocations, so that in the shell each command does not need
+to re-dial and re-authenticate. Sessions are keyed by the transport, typically
+on address and credentials
+*/
+type Sessions struct {
+	sync.Mutex
+	sessions map[string]io.Closer
+
+	// opening holds a channel per key being opened, closed once the open returns
+	opening map[string]chan struct{}
+}
+
+// NewSessions returns an empty session manager
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: map[string]io.Closer{},
+		opening:  map[string]chan struct{}{},
+	}
+}
+
+/*
+Get returns the session stored under key, calling open to create it when there
+is none, reused reports whether the session existed before the call. open is
+called without holding the lock, concurrent calls for the same key wait for it
+and share the session, or open their own if it failed
+*/
+func (s *Sessions) Get(key string, open func() (io.Closer, error)) (session io.Closer, reused bool, err error) {
+	s.Lock()
+	for {
+		if session, ok := s.sessions[key]; ok {
+			s.Unlock()
+			return session, true, nil
+		}
+
+		opening, ok := s.opening[key]
+		if !ok {
+			break
+		}
+		s.Unlock()
+		<-opening
+		s.Lock()
+	}
+
+	opening := make(chan struct{})
+	s.opening[key] = opening
+	s.Unlock()
+
+	session, err = open()
+
+	s.Lock()
+	delete(s.opening, key)
+	if err == nil {
+		s.sessions[key] = session
+	}
+	s.Unlock()
+	close(opening)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return session, false, nil
+}
+
+// Drop closes the session and removes it, if it is still the session stored under key
+func (s *Sessions) Drop(key string, session io.Closer) {
+	s.Lock()
+	if s.sessions[key] == session {
+		delete(s.sessions, key)
+	}
+	s.Unlock()
+
+	session.Close()
+}
+
+// Close closes and removes all sessions, returning the first error encountered
+func (s *Sessions) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	var firstErr error
+	for key, session := range s.sessions {
+		err := session.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.sessions, key)
+	}
+
+	return firstErr
+}
@@ -0,0 +1,119 @@
+package combi
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionsOpenOutsideLock(t *testing.T) {
+	s := NewSessions()
+
+	// a slow open does not hold up other keys
+	release := make(chan struct{})
+	slow := make(chan error, 1)
+	go func() {
+		_, _, err := s.Get("slow", func() (io.Closer, error) {
+			<-release
+			return &closeRecorder{}, nil
+		})
+		slow <- err
+	}()
+
+	got := make(chan error, 1)
+	go func() {
+		_, _, err := s.Get("fast", func() (io.Closer, error) {
+			return &closeRecorder{}, nil
+		})
+		got <- err
+	}()
+
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked by an open for another key")
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionsShareOpen(t *testing.T) {
+	s := NewSessions()
+
+	var opens int32
+	session := &closeRecorder{}
+	open := func() (io.Closer, error) {
+		atomic.AddInt32(&opens, 1)
+		time.Sleep(10 * time.Millisecond)
+		return session, nil
+	}
+
+	var wg sync.WaitGroup
+	var fresh int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, reused, err := s.Get("server", open)
+			if err != nil || got != session {
+				t.Errorf("Get returned %v, %v, want the shared session", got, err)
+			}
+			if !reused {
+				atomic.AddInt32(&fresh, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if opens != 1 || fresh != 1 {
+		t.Errorf("%d opens, %d fresh sessions, want one", opens, fresh)
+	}
+}
+
+func TestSessionsFailedOpen(t *testing.T) {
+	s := NewSessions()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		_, _, err := s.Get("server", func() (io.Closer, error) {
+			close(started)
+			<-release
+			return nil, errors.New("connection refused")
+		})
+		failed <- err
+	}()
+	<-started
+
+	// waiters open their own session when the open they waited on fails
+	session := &closeRecorder{}
+	got := make(chan io.Closer, 1)
+	go func() {
+		s, _, _ := s.Get("server", func() (io.Closer, error) {
+			return session, nil
+		})
+		got <- s
+	}()
+
+	close(release)
+	if err := <-failed; err == nil {
+		t.Error("failed open returned no error")
+	}
+	if <-got != session {
+		t.Error("waiter did not open its own session")
+	}
+
+	if err := s.Close(); err != nil || !session.closed {
+		t.Errorf("close error %v, session closed %t", err, session.closed)
+	}
+}
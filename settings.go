@@ -0,0 +1,174 @@
+package combi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/abiosoft/ishell.v2"
+)
+
+/*
+settingValue adapts a commander getter / setter pair to pflag.Value, settings
+are bound as persistent flags on the root command so the same value can be set
+from the static cli (--output json) or from the shell (set output json)
+*/
+type settingValue struct {
+	typ string
+	get func() string
+	set func(string) error
+}
+
+func (s *settingValue) String() string     { return s.get() }
+func (s *settingValue) Set(v string) error { return s.set(v) }
+func (s *settingValue) Type() string       { return s.typ }
+
+// bindSettings registers the commander settings as persistent flags on the root command
+func (c *Commander) bindSettings() {
+	if c.rootCmd == nil {
+		return
+	}
+
+	flags := c.rootCmd.PersistentFlags()
+	flags.VarP(&settingValue{
+		typ: "format",
+		get: c.OutputFormat,
+		set: c.SetOutputFormat,
+	}, "output", "o", fmt.Sprintf("output format (%s)", strings.Join(c.Formats(), ", ")))
+	flags.Var(&settingValue{
+		typ: "expression",
+		get: c.Query,
+		set: c.SetQuery,
+	}, "query", "query expression selecting / filtering the response, e.g. Tasks[?Status=='Done'].Name")
+	flags.Var(&settingValue{
+		typ: "path",
+		get: c.OutputFile,
+		set: c.SetOutputFile,
+	}, "out", "write responses to a file instead of the terminal")
+	flags.VarPF(&settingValue{
+		typ: "bool",
+		get: func() string { return strconv.FormatBool(c.Tee()) },
+		set: func(v string) error {
+			tee, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			c.SetTee(tee)
+			return nil
+		},
+	}, "tee", "", "write responses to the terminal as well as the --out file").NoOptDefVal = "true"
+	flags.Var(&settingValue{
+		typ: "columns",
+		get: func() string { return strings.Join(c.TableOptions().Columns, ",") },
+		set: func(v string) error {
+			t := c.TableOptions()
+			t.Columns = nil
+			for _, col := range strings.Split(v, ",") {
+				if col = strings.TrimSpace(col); col != "" {
+					t.Columns = append(t.Columns, col)
+				}
+			}
+			c.SetTableOptions(t)
+			return nil
+		},
+	}, "columns", "comma separated columns to include in table / csv output")
+	flags.Var(&settingValue{
+		typ: "column",
+		get: func() string { return c.TableOptions().SortBy },
+		set: func(v string) error {
+			t := c.TableOptions()
+			t.SortBy = v
+			c.SetTableOptions(t)
+			return nil
+		},
+	}, "sort-by", "column to sort table / csv output by")
+	flags.VarPF(&settingValue{
+		typ: "bool",
+		get: func() string { return strconv.FormatBool(c.TableOptions().NoHeaders) },
+		set: func(v string) error {
+			noHeaders, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			t := c.TableOptions()
+			t.NoHeaders = noHeaders
+			c.SetTableOptions(t)
+			return nil
+		},
+	}, "no-headers", "", "omit the header row from table / csv output").NoOptDefVal = "true"
+	flags.Var(&settingValue{
+		typ: "duration",
+		get: func() string { return c.TimeoutOverride().String() },
+		set: func(v string) error {
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			c.SetTimeoutOverride(timeout)
+			return nil
+		},
+	}, "timeout", "request timeout for all commands (e.g. 30s), overrides command timeouts")
+	flags.Var(&settingValue{
+		typ: "codec",
+		get: c.CodecName,
+		set: c.SetCodec,
+	}, "codec", "wire format used by transports and request files (xml, json, yaml)")
+	flags.Var(&settingValue{
+		typ: "path",
+		get: c.RequestFile,
+		set: c.SetRequestFile,
+	}, "request-file", "load the request from a file (.xml, .json, .yaml), flags override its values")
+}
+
+// shellSetCmd returns the shell command used to view and change settings
+func (c *Commander) shellSetCmd() *ishell.Cmd {
+	return &ishell.Cmd{
+		Name: "set",
+		Help: "view or change a setting, e.g. set output json",
+		Func: func(sc *ishell.Context) {
+			flags := c.rootCmd.PersistentFlags()
+
+			// no args, list the current settings
+			if len(sc.Args) == 0 {
+				printSettings(sc, flags)
+				return
+			}
+
+			flag := flags.Lookup(sc.Args[0])
+			if flag == nil {
+				c.HandleShellError(sc, classify(ErrUsage, fmt.Errorf("unknown setting: %s", sc.Args[0])))
+				return
+			}
+
+			err := flag.Value.Set(strings.Join(sc.Args[1:], " "))
+			if err != nil {
+				c.HandleShellError(sc, err)
+				return
+			}
+			flag.Changed = true
+		},
+	}
+}
+
+// printSettings prints all settings with their current values, padding to line up values
+func printSettings(f FormatPrinter, flags *pflag.FlagSet) {
+	longestName := 0
+	names := []string{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		names = append(names, flag.Name)
+		if len(flag.Name) > longestName {
+			longestName = len(flag.Name)
+		}
+	})
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		padLen := longestName - len(name)
+		f.Printf("%s = %s\n", (name + strings.Repeat(" ", padLen)), flag.Value.String())
+	}
+}
@@ -33,7 +33,11 @@ present:
 
 		c.Println()
 		c.Println("Select an option: ")
-		selected := c.ReadLine()
+		selected, err := c.ReadLineErr()
+		if err != nil {
+			// input closed or interrupted, nothing more to select
+			return -1
+		}
 		intVal, err := strconv.Atoi(selected)
 		if err != nil {
 			shellPrintError(c, errorInvalidOption)
@@ -122,7 +126,10 @@ func collectShellValue(c *ishell.Context, fi *FieldInfo) error {
 	}
 
 	c.Print(fi.Name + ":")
-	strVal := c.ReadLine()
+	strVal, err := c.ReadLineErr()
+	if err != nil {
+		return err
+	}
 
 	switch fi.Field.Kind() {
 	case reflect.String:
@@ -131,7 +138,7 @@ func collectShellValue(c *ishell.Context, fi *FieldInfo) error {
 	case reflect.Int:
 		intVal, err := strconv.Atoi(strVal)
 		if err != nil || fi.Field.OverflowInt(int64(intVal)) {
-			return fmt.Errorf("failed to convert user input to integer")
+			return classify(ErrValidation, errors.New("failed to convert user input to integer"))
 		}
 
 		fi.Field.SetInt(int64(intVal))
@@ -0,0 +1,68 @@
+package combi
+
+import "context"
+
+/*
+StatusCarrier is implemented by responses which report a status inside their
+body (such as the status and status_text attributes of OMP responses), the
+default status check turns statuses outside the 2xx range into a *StatusError
+*/
+type StatusCarrier interface {
+	// ResponseStatus returns the status code and text, a zero code means no status was reported
+	ResponseStatus() (code int, text string)
+}
+
+/*
+StatusCheck inspects a response once the request handler has returned without
+error, returning an error when the response reports a failure
+*/
+type StatusCheck func(response interface{}) error
+
+/*
+OMPStatus can be embedded in response structs to decode the OMP status
+attributes and satisfy StatusCarrier
+*/
+type OMPStatus struct {
+	Code int    `xml:"status,attr" json:"status,omitempty" col:"-"`
+	Text string `xml:"status_text,attr" json:"status_text,omitempty" col:"-"`
+}
+
+// ResponseStatus returns the decoded status attributes
+func (s OMPStatus) ResponseStatus() (int, string) {
+	return s.Code, s.Text
+}
+
+// CheckStatus is the default StatusCheck, returning a *StatusError for StatusCarrier responses with an error status
+func CheckStatus(response interface{}) error {
+	sc, ok := response.(StatusCarrier)
+	if !ok {
+		return nil
+	}
+
+	code, text := sc.ResponseStatus()
+	if code == 0 || (code >= 200 && code <= 299) {
+		return nil
+	}
+
+	return &StatusError{Code: code, Text: text}
+}
+
+// withStatusCheck wraps the handler with the command status check, or the commander status check when not set
+func (c *Command) withStatusCheck(next RequestHandler) RequestHandler {
+	check := c.StatusCheck
+	if check == nil {
+		check = c.Commander.StatusCheck()
+	}
+	if check == nil {
+		return next
+	}
+
+	return func(ctx context.Context, req, resp interface{}) error {
+		err := next(ctx, req, resp)
+		if err != nil {
+			return err
+		}
+
+		return check(resp)
+	}
+}
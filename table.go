@@ -0,0 +1,386 @@
+package combi
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNotTabular is returned by the table and csv formatters when a response holds no list to render
+var ErrNotTabular = errors.New("response does not contain a list to tabulate")
+
+// minColumnWidth is the narrowest a column will be truncated to when fitting the terminal
+const minColumnWidth = 4
+
+/*
+Table holds the options used to render list responses as aligned tables or
+csv. Column headers are taken from the col struct tag (col:"Name,width=20")
+falling back to the field name, col:"-" hides a field
+*/
+type Table struct {
+	// Columns selects and orders columns by header or field name, all columns when empty
+	Columns []string
+
+	// SortBy sorts rows by the named column, numerically when all values are numbers
+	SortBy string
+
+	// NoHeaders omits the header row
+	NoHeaders bool
+}
+
+// tableColumn describes a single rendered column
+type tableColumn struct {
+	Header string
+	Field  string
+	Width  int
+}
+
+// TableFormatter renders the first list found in the response as an aligned table using default options
+func TableFormatter(w io.Writer, resp interface{}) error {
+	return Table{}.Format(w, resp)
+}
+
+// CSVFormatter renders the first list found in the response as comma separated values using default options
+func CSVFormatter(w io.Writer, resp interface{}) error {
+	return Table{}.FormatCSV(w, resp)
+}
+
+/*
+Format renders the first list found in the response as an aligned table, when
+writing to a terminal Output the table is truncated to the terminal width
+*/
+func (t Table) Format(w io.Writer, resp interface{}) error {
+	cols, rows, err := t.tabulate(resp)
+	if err != nil {
+		return err
+	}
+
+	// measure columns, honouring any width set on the col tag
+	widths := make([]int, len(cols))
+	for i, col := range cols {
+		if !t.NoHeaders {
+			widths[i] = utf8.RuneCountInString(col.Header)
+		}
+		for _, row := range rows {
+			if l := utf8.RuneCountInString(row[i]); l > widths[i] {
+				widths[i] = l
+			}
+		}
+		if col.Width > 0 && widths[i] > col.Width {
+			widths[i] = col.Width
+		}
+	}
+
+	if out, ok := w.(*Output); ok && out.Terminal && out.Width > 0 {
+		fitWidths(widths, out.Width)
+	}
+
+	if !t.NoHeaders {
+		headers := make([]string, len(cols))
+		for i, col := range cols {
+			headers[i] = col.Header
+		}
+		rows = append([][]string{headers}, rows...)
+	}
+
+	for _, row := range rows {
+		line := make([]string, len(row))
+		for i, cell := range row {
+			cell = truncate(cell, widths[i])
+
+			// don't pad the last column, avoids trailing whitespace
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			}
+			line[i] = cell
+		}
+
+		_, err = fmt.Fprintln(w, strings.Join(line, "  "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FormatCSV renders the first list found in the response as comma separated values
+func (t Table) FormatCSV(w io.Writer, resp interface{}) error {
+	cols, rows, err := t.tabulate(resp)
+	if err != nil {
+		return err
+	}
+
+	if !t.NoHeaders {
+		headers := make([]string, len(cols))
+		for i, col := range cols {
+			headers[i] = col.Header
+		}
+		rows = append([][]string{headers}, rows...)
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("unable to write csv: %w", err)
+	}
+
+	return nil
+}
+
+// tabulate flattens the response then applies the column selection and sorting
+func (t Table) tabulate(resp interface{}) ([]tableColumn, [][]string, error) {
+	cols, rows, err := tabulate(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(t.Columns) > 0 {
+		indexes := make([]int, len(t.Columns))
+		selected := make([]tableColumn, len(t.Columns))
+		for i, name := range t.Columns {
+			indexes[i] = columnIndex(cols, name)
+			if indexes[i] < 0 {
+				return nil, nil, classify(ErrUsage, fmt.Errorf("unknown column: %s", name))
+			}
+			selected[i] = cols[indexes[i]]
+		}
+
+		for r, row := range rows {
+			selectedRow := make([]string, len(indexes))
+			for i, index := range indexes {
+				selectedRow[i] = row[index]
+			}
+			rows[r] = selectedRow
+		}
+		cols = selected
+	}
+
+	if t.SortBy != "" {
+		index := columnIndex(cols, t.SortBy)
+		if index < 0 {
+			return nil, nil, classify(ErrUsage, fmt.Errorf("unknown sort column: %s", t.SortBy))
+		}
+		sortRows(rows, index)
+	}
+
+	return cols, rows, nil
+}
+
+// columnIndex finds a column by header or field name, ignoring case
+func columnIndex(cols []tableColumn, name string) int {
+	for i, col := range cols {
+		if strings.EqualFold(col.Header, name) || strings.EqualFold(col.Field, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sortRows sorts rows by the column at index, numerically when every value is a number
+func sortRows(rows [][]string, index int) {
+	numeric := true
+	for _, row := range rows {
+		if _, err := strconv.ParseFloat(row[index], 64); err != nil {
+			numeric = false
+			break
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if numeric {
+			a, _ := strconv.ParseFloat(rows[i][index], 64)
+			b, _ := strconv.ParseFloat(rows[j][index], 64)
+			return a < b
+		}
+		return rows[i][index] < rows[j][index]
+	})
+}
+
+// fitWidths shrinks the widest columns until the table, including gutters, fits within max
+func fitWidths(widths []int, max int) {
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+
+	for total > max {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			return
+		}
+		widths[widest]--
+		total--
+	}
+}
+
+// truncate shortens text to width runes, marking the cut with an ellipsis
+func truncate(text string, width int) string {
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	if width <= 3 {
+		return string([]rune(text)[:width])
+	}
+
+	return string([]rune(text)[:width-3]) + "..."
+}
+
+/*
+tabulate finds the first slice within the response (the response itself, or
+the first slice field found walking the struct depth first) and flattens it
+into columns and string rows, struct elements produce a column per field
+(even when the list is empty), map elements (such as query projections) a
+column per key of the first element
+*/
+func tabulate(resp interface{}) (cols []tableColumn, rows [][]string, err error) {
+	list, ok := findSlice(reflect.ValueOf(resp))
+	if !ok {
+		return nil, nil, ErrNotTabular
+	}
+
+	// the element type decides the shape of the table, so empty lists keep their columns
+	var first reflect.Value
+	for i := 0; i < list.Len() && !first.IsValid(); i++ {
+		first = indirect(list.Index(i))
+	}
+
+	elemType := list.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() == reflect.Interface && first.IsValid() {
+		elemType = first.Type()
+	}
+
+	switch elemType.Kind() {
+	case reflect.Struct:
+		fields := []int{}
+		for i := 0; i < elemType.NumField(); i++ {
+			sf := elemType.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			if _, ok := excludeFieldNames[sf.Name]; ok {
+				continue
+			}
+			col, ok := structColumn(sf)
+			if !ok {
+				continue
+			}
+			fields = append(fields, i)
+			cols = append(cols, col)
+		}
+
+		for i := 0; i < list.Len(); i++ {
+			elem := indirect(list.Index(i))
+			row := make([]string, len(fields))
+			if elem.IsValid() && elem.Type() == elemType {
+				for j, f := range fields {
+					row[j] = formatCell(elem.Field(f))
+				}
+			}
+			rows = append(rows, row)
+		}
+
+	case reflect.Map:
+		// map keys are only known from the data, the first element decides them
+		for _, key := range mapKeys(first) {
+			name := formatCell(key)
+			cols = append(cols, tableColumn{Header: name, Field: name})
+		}
+		sort.Slice(cols, func(i, j int) bool { return cols[i].Header < cols[j].Header })
+
+		for i := 0; i < list.Len(); i++ {
+			elem := indirect(list.Index(i))
+			row := make([]string, len(cols))
+			if elem.Kind() == reflect.Map && elem.Type().Key().Kind() == reflect.String {
+				for j, col := range cols {
+					row[j] = formatCell(elem.MapIndex(reflect.ValueOf(col.Field).Convert(elem.Type().Key())))
+				}
+			}
+			rows = append(rows, row)
+		}
+
+	default:
+		// scalar lists get a single value column
+		cols = []tableColumn{{Header: "Value", Field: "Value"}}
+		for i := 0; i < list.Len(); i++ {
+			rows = append(rows, []string{formatCell(list.Index(i))})
+		}
+	}
+
+	return cols, rows, nil
+}
+
+// mapKeys returns the keys of a map value, none for the zero value
+func mapKeys(val reflect.Value) []reflect.Value {
+	if val.Kind() != reflect.Map {
+		return nil
+	}
+
+	return val.MapKeys()
+}
+
+// structColumn builds a column from a struct field and its col tag, returning false for hidden fields
+func structColumn(sf reflect.StructField) (tableColumn, bool) {
+	col := tableColumn{Header: sf.Name, Field: sf.Name}
+
+	tag, ok := sf.Tag.Lookup("col")
+	if !ok {
+		return col, true
+	}
+	if tag == "-" {
+		return col, false
+	}
+
+	parts := strings.Split(tag, ",")
+	if parts[0] != "" {
+		col.Header = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		if strings.HasPrefix(opt, "width=") {
+			col.Width, _ = strconv.Atoi(strings.TrimPrefix(opt, "width="))
+		}
+	}
+
+	return col, true
+}
+
+// findSlice walks the value depth first returning the first slice or array found
+func findSlice(val reflect.Value) (reflect.Value, bool) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Value{}, false
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		return val, true
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			if val.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if list, ok := findSlice(val.Field(i)); ok {
+				return list, true
+			}
+		}
+	}
+
+	return reflect.Value{}, false
+}
@@ -0,0 +1,70 @@
+package combi
+
+import (
+	"bytes"
+	"testing"
+)
+
+type tableTask struct {
+	ID     string `col:"ID"`
+	Name   string `col:"Name"`
+	Secret string `col:"-"`
+}
+
+type tableTasksResponse struct {
+	Status string
+	Tasks  []*tableTask
+}
+
+func TestTableEmptyList(t *testing.T) {
+	tests := []struct {
+		desc  string
+		table Table
+		want  string
+	}{
+		{"all columns", Table{}, "ID  Name\n"},
+		{"selected columns", Table{Columns: []string{"Name"}}, "Name\n"},
+		{"sorted", Table{SortBy: "ID"}, "ID  Name\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.table.Format(&buf, &tableTasksResponse{Status: "200"}); err != nil {
+			t.Errorf("%s: %s", test.desc, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s: table %q, want %q", test.desc, buf.String(), test.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := (Table{}).FormatCSV(&buf, []tableTask{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ID,Name\n" {
+		t.Errorf("csv %q, want the header only", buf.String())
+	}
+}
+
+func TestTableNilElements(t *testing.T) {
+	resp := &tableTasksResponse{Tasks: []*tableTask{nil, {ID: "1", Name: "weekly scan"}}}
+
+	var buf bytes.Buffer
+	if err := (Table{Columns: []string{"Name", "ID"}}).FormatCSV(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Name,ID\n,\nweekly scan,1\n"; buf.String() != want {
+		t.Errorf("csv %q, want %q", buf.String(), want)
+	}
+}
+
+func TestTableScalarList(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (Table{}).Format(&buf, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Value\na\nb\n"; buf.String() != want {
+		t.Errorf("table %q, want %q", buf.String(), want)
+	}
+}
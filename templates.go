@@ -0,0 +1,181 @@
+package combi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Names of the built in template output formats
+const (
+	FormatTemplate     = "template"
+	FormatTemplateFile = "template-file"
+)
+
+// ansiColours maps colour names usable in templates to their terminal escape codes
+var ansiColours = map[string]string{
+	"black":   "\x1b[30m",
+	"red":     "\x1b[31m",
+	"green":   "\x1b[32m",
+	"yellow":  "\x1b[33m",
+	"blue":    "\x1b[34m",
+	"magenta": "\x1b[35m",
+	"cyan":    "\x1b[36m",
+	"white":   "\x1b[37m",
+	"bold":    "\x1b[1m",
+}
+
+const ansiReset = "\x1b[0m"
+
+/*
+TemplateFuncs are the helper functions available to response templates:
+
+	join ", " .Names        join a list with a separator
+	date "2006-01-02" .Time format a time.Time, RFC3339 string or unix seconds
+	color "red" .Status     wrap text in a terminal colour (colour is an alias)
+	pad 20 .Name            pad text on the right to a width
+	padLeft 8 .Count        pad text on the left to a width
+	upper / lower           change case
+*/
+var TemplateFuncs = template.FuncMap{
+	"join":    templateJoin,
+	"date":    templateDate,
+	"color":   templateColour,
+	"colour":  templateColour,
+	"pad":     templatePad,
+	"padLeft": templatePadLeft,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+}
+
+// defaultFormatterFactories returns the built in parameterised formatters keyed by name
+func defaultFormatterFactories() map[string]FormatterFactory {
+	return map[string]FormatterFactory{
+		FormatTemplate:     templateFormatterFactory,
+		FormatTemplateFile: templateFileFormatterFactory,
+	}
+}
+
+// TemplateFormatter returns a Formatter rendering the response through the supplied text/template
+func TemplateFormatter(text string) (Formatter, error) {
+	tmpl, err := template.New("response").Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %w", err)
+	}
+
+	return func(w io.Writer, resp interface{}) error {
+		buf := &bytes.Buffer{}
+		err := tmpl.Execute(buf, resp)
+		if err != nil {
+			return fmt.Errorf("unable to execute template: %w", err)
+		}
+
+		// always finish on a new line so the prompt / shell is left tidy
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+
+		_, err = buf.WriteTo(w)
+		return err
+	}, nil
+}
+
+// templateFormatterFactory handles -o template=..., falling back to the command template when no text is given
+func templateFormatterFactory(cmd *Command, text string) (Formatter, error) {
+	if text == "" && cmd != nil {
+		if cmd.Template == "" {
+			return nil, classify(ErrUsage, fmt.Errorf("no template supplied and no default template defined for %s", cmd.Name))
+		}
+		text = cmd.Template
+	}
+
+	return TemplateFormatter(text)
+}
+
+// templateFileFormatterFactory handles -o template-file=path
+func templateFileFormatterFactory(cmd *Command, path string) (Formatter, error) {
+	if path == "" {
+		return nil, classify(ErrUsage, errors.New("no template file supplied"))
+	}
+
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template file: %w", err)
+	}
+
+	return TemplateFormatter(string(text))
+}
+
+func templateJoin(sep string, list interface{}) (string, error) {
+	val := reflect.ValueOf(list)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return "", fmt.Errorf("join expects a list, got %s", val.Kind())
+	}
+
+	parts := make([]string, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		parts[i] = formatCell(val.Index(i))
+	}
+
+	return strings.Join(parts, sep), nil
+}
+
+func templateDate(layout string, value interface{}) (string, error) {
+	switch t := value.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		if t == nil {
+			return "", nil
+		}
+		return t.Format(layout), nil
+	case int:
+		return time.Unix(int64(t), 0).Format(layout), nil
+	case int64:
+		return time.Unix(t, 0).Format(layout), nil
+	case string:
+		if t == "" {
+			return "", nil
+		}
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse date: %w", err)
+		}
+		return parsed.Format(layout), nil
+	default:
+		return "", fmt.Errorf("unsupported date value: %T", value)
+	}
+}
+
+func templateColour(colour string, value interface{}) (string, error) {
+	code, ok := ansiColours[colour]
+	if !ok {
+		return "", fmt.Errorf("unknown colour: %s", colour)
+	}
+
+	return code + fmt.Sprint(value) + ansiReset, nil
+}
+
+func templatePad(width int, value interface{}) string {
+	text := fmt.Sprint(value)
+	if len(text) >= width {
+		return text
+	}
+
+	return text + strings.Repeat(" ", width-len(text))
+}
+
+func templatePadLeft(width int, value interface{}) string {
+	text := fmt.Sprint(value)
+	if len(text) >= width {
+		return text
+	}
+
+	return strings.Repeat(" ", width-len(text)) + text
+}
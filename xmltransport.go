@@ -0,0 +1,257 @@
+package combi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+XMLTransport provides a RequestHandler for OMP style servers, the request is
+marshalled to an XML document and written to a plain or TLS TCP connection,
+the reply element is read back into the response. Status attributes on the
+reply (status / status_text) outside of the 2xx range are returned as a
+*StatusError
+*/
+type XMLTransport struct {
+	// Address is the host:port of the server
+	Address string
+
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+
+	// Username and Password are sent in an authenticate command before the request when Username is set
+	Username string
+	Password string
+
+	// Dial overrides how connections are made, e.g. to connect to a local stand in server
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// authenticate is the OMP authenticate command
+type authenticate struct {
+	XMLName     xml.Name `xml:"authenticate"`
+	Credentials struct {
+		Username string `xml:"username"`
+		Password string `xml:"password"`
+	} `xml:"credentials"`
+}
+
+// authenticateResponse holds the reply to an authenticate command
+type authenticateResponse struct {
+	XMLName xml.Name `xml:"authenticate_response"`
+}
+
+// RequestHandler returns the transport as a RequestHandler
+func (t *XMLTransport) RequestHandler() RequestHandler {
+	return t.Handle
+}
+
+/*
+Handle sends the request and reads the response. When called through a Command
+the authenticated connection is kept in the commander Sessions and reused by
+later requests, a failed reused connection is redialled once. Otherwise a new
+connection is dialled (and authenticated) for the request
+*/
+func (t *XMLTransport) Handle(ctx context.Context, req, resp interface{}) error {
+	cmd, ok := CommandFromContext(ctx)
+	if !ok || cmd.Commander == nil {
+		conn, err := t.connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		return conn.roundTrip(ctx, req, resp)
+	}
+
+	sessions := cmd.Commander.Sessions()
+	key := t.sessionKey()
+	for {
+		session, reused, err := sessions.Get(key, func() (io.Closer, error) {
+			return t.connect(ctx)
+		})
+		if err != nil {
+			return err
+		}
+
+		conn := session.(*xmlConn)
+		conn.Lock()
+		err = conn.roundTrip(ctx, req, resp)
+		conn.Unlock()
+
+		// server reported errors leave the connection usable
+		var statusErr *StatusError
+		if err == nil || errors.As(err, &statusErr) {
+			return err
+		}
+
+		// the connection is unusable, retry once if it may just have gone stale
+		sessions.Drop(key, conn)
+		if !reused || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// sessionKey identifies connections which can be shared
+func (t *XMLTransport) sessionKey() string {
+	return fmt.Sprintf("xml://%s@%s?tls=%t", t.Username, t.Address, t.TLSConfig != nil)
+}
+
+// connect dials the server and authenticates the connection
+func (t *XMLTransport) connect(ctx context.Context) (*xmlConn, error) {
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	raw, err := dial(ctx, "tcp", t.Address)
+	if err != nil {
+		return nil, classify(ErrTransport, fmt.Errorf("unable to connect to %s: %w", t.Address, err))
+	}
+
+	if t.TLSConfig != nil {
+		cfg := t.TLSConfig
+		if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(t.Address)
+		}
+		tlsConn := tls.Client(raw, cfg)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			raw.Close()
+			return nil, classify(ErrTransport, fmt.Errorf("tls handshake with %s failed: %w", t.Address, err))
+		}
+		raw = tlsConn
+	}
+
+	conn := &xmlConn{conn: raw, dec: xml.NewDecoder(raw)}
+
+	if t.Username != "" {
+		auth := &authenticate{}
+		auth.Credentials.Username = t.Username
+		auth.Credentials.Password = t.Password
+
+		// only a rejection by the server is an auth error, transport and context errors keep their class
+		err = conn.roundTrip(ctx, auth, &authenticateResponse{})
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			conn.Close()
+			return nil, classify(ErrAuth, fmt.Errorf("authentication failed: %w", err))
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to authenticate: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// xmlConn is a connection exchanging XML documents, the lock is held for each round trip
+type xmlConn struct {
+	sync.Mutex
+	conn net.Conn
+	dec  *xml.Decoder
+}
+
+func (c *xmlConn) Close() error {
+	return c.conn.Close()
+}
+
+/*
+roundTrip writes the request and decodes the reply into resp, the connection
+deadline follows the context and the connection is closed if the context is
+cancelled mid request
+*/
+func (c *xmlConn) roundTrip(ctx context.Context, req, resp interface{}) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	// unblock reads / writes on cancellation
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-done:
+		}
+	}()
+
+	err := c.exchange(req, resp)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (c *xmlConn) exchange(req, resp interface{}) error {
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("unable to marshal request: %w", err)
+	}
+
+	_, err = c.conn.Write(body)
+	if err != nil {
+		return classify(ErrTransport, fmt.Errorf("unable to send request: %w", err))
+	}
+
+	// skip to the reply element
+	var start xml.StartElement
+	for {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return classify(ErrTransport, fmt.Errorf("unable to read response: %w", err))
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			start = se
+			break
+		}
+	}
+
+	err = c.dec.DecodeElement(resp, &start)
+	if err != nil {
+		return classify(ErrTransport, fmt.Errorf("unable to decode response: %w", err))
+	}
+
+	return statusFromAttrs(start.Attr)
+}
+
+// statusFromAttrs returns a *StatusError when the status attribute is outside the 2xx range
+func statusFromAttrs(attrs []xml.Attr) error {
+	var status, text string
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "status":
+			status = attr.Value
+		case "status_text":
+			text = attr.Value
+		}
+	}
+
+	if status == "" {
+		return nil
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return classify(ErrTransport, errors.New("invalid response status: "+status))
+	}
+	if code < 200 || code > 299 {
+		return &StatusError{Code: code, Text: text}
+	}
+
+	return nil
+}
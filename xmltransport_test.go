@@ -0,0 +1,291 @@
+package combi
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+type getTasks struct {
+	XMLName xml.Name `xml:"get_tasks"`
+	Filter  string   `xml:"filter,attr,omitempty"`
+}
+
+type getTasksResponse struct {
+	XMLName xml.Name `xml:"get_tasks_response"`
+	Tasks   []string `xml:"task>name"`
+}
+
+type deleteTask struct {
+	XMLName xml.Name `xml:"delete_task"`
+	TaskID  string   `xml:"task_id,attr"`
+}
+
+type deleteTaskResponse struct {
+	XMLName xml.Name `xml:"delete_task_response"`
+}
+
+// standIn is a local OMP style server accepting one user
+type standIn struct {
+	ln       net.Listener
+	username string
+	password string
+
+	sync.Mutex
+	conns         []net.Conn
+	accepted      int
+	authenticated int
+	requests      []string
+}
+
+func newStandIn(t *testing.T) *standIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &standIn{ln: ln, username: "admin", password: "secret"}
+	go s.serve()
+
+	return s
+}
+
+func (s *standIn) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.conns = append(s.conns, conn)
+		s.accepted++
+		s.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *standIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	authenticated := false
+	dec := xml.NewDecoder(conn)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		s.Lock()
+		s.requests = append(s.requests, start.Name.Local)
+		s.Unlock()
+
+		var reply string
+		switch start.Name.Local {
+		case "authenticate":
+			auth := &authenticate{}
+			if err := dec.DecodeElement(auth, &start); err != nil {
+				return
+			}
+			if auth.Credentials.Username != s.username || auth.Credentials.Password != s.password {
+				reply = `<authenticate_response status="400" status_text="Authentication failed"/>`
+				break
+			}
+
+			authenticated = true
+			s.Lock()
+			s.authenticated++
+			s.Unlock()
+			reply = `<authenticate_response status="200" status_text="OK"/>`
+
+		case "get_tasks":
+			dec.Skip()
+			reply = `<get_tasks_response status="200" status_text="OK"><task><name>weekly scan</name></task></get_tasks_response>`
+
+		case "delete_task":
+			dec.Skip()
+			reply = `<delete_task_response status="404" status_text="Failed to find task"/>`
+
+		default:
+			dec.Skip()
+			reply = `<response status="400" status_text="Bogus command"/>`
+		}
+
+		if !authenticated && start.Name.Local != "authenticate" {
+			reply = `<response status="401" status_text="Authenticate first"/>`
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// dropConnections closes the open connections, as a server timing out idle clients would
+func (s *standIn) dropConnections() {
+	s.Lock()
+	defer s.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *standIn) Close() {
+	s.ln.Close()
+	s.dropConnections()
+}
+
+func (s *standIn) counts() (accepted, authenticated int, requests []string) {
+	s.Lock()
+	defer s.Unlock()
+	return s.accepted, s.authenticated, append([]string{}, s.requests...)
+}
+
+// newXMLCommand registers a command sending req through the transport
+func newXMLCommand(t *testing.T, transport *XMLTransport, name string, req, resp interface{}) (*Commander, *Command) {
+	c := NewCommander(&cobra.Command{Use: "app"})
+	cmd := &Command{
+		Name:           name,
+		Request:        req,
+		Response:       resp,
+		RequestHandler: transport.RequestHandler(),
+	}
+	if err := c.Add(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, cmd
+}
+
+func TestXMLTransportAuthenticates(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	transport := &XMLTransport{Address: srv.ln.Addr().String(), Username: "admin", Password: "secret"}
+	c, cmd := newXMLCommand(t, transport, "get-tasks", &getTasks{}, &getTasksResponse{})
+	defer c.Close()
+
+	resp := &getTasksResponse{}
+	if err := cmd.HandleRequest(context.Background(), &getTasks{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0] != "weekly scan" {
+		t.Errorf("tasks %v, want [weekly scan]", resp.Tasks)
+	}
+
+	_, authenticated, requests := srv.counts()
+	if authenticated != 1 || len(requests) != 2 || requests[0] != "authenticate" || requests[1] != "get_tasks" {
+		t.Errorf("server saw %v (%d authenticated), want authenticate then get_tasks", requests, authenticated)
+	}
+}
+
+func TestXMLTransportAuthenticationFailure(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	transport := &XMLTransport{Address: srv.ln.Addr().String(), Username: "admin", Password: "wrong"}
+	c, cmd := newXMLCommand(t, transport, "get-tasks", &getTasks{}, &getTasksResponse{})
+	defer c.Close()
+
+	err := cmd.HandleRequest(context.Background(), &getTasks{}, &getTasksResponse{})
+	if !errors.Is(err, ErrAuth) {
+		t.Fatalf("error %v, want ErrAuth", err)
+	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != 400 {
+		t.Errorf("error %v, want the 400 authenticate status", err)
+	}
+}
+
+func TestXMLTransportStatusError(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	transport := &XMLTransport{Address: srv.ln.Addr().String(), Username: "admin", Password: "secret"}
+	c, cmd := newXMLCommand(t, transport, "delete-task", &deleteTask{}, &deleteTaskResponse{})
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		err := cmd.HandleRequest(context.Background(), &deleteTask{TaskID: "1"}, &deleteTaskResponse{})
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("error %v, want a *StatusError", err)
+		}
+		if statusErr.Code != 404 || statusErr.Text != "Failed to find task" {
+			t.Errorf("status %d %q, want 404 Failed to find task", statusErr.Code, statusErr.Text)
+		}
+		if !errors.Is(err, ErrServer) {
+			t.Errorf("error %v, want ErrServer", err)
+		}
+	}
+
+	// status errors leave the session usable
+	if accepted, _, _ := srv.counts(); accepted != 1 {
+		t.Errorf("%d connections, want the session reused", accepted)
+	}
+}
+
+func TestXMLTransportRedialsStaleSession(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	transport := &XMLTransport{Address: srv.ln.Addr().String(), Username: "admin", Password: "secret"}
+	c, cmd := newXMLCommand(t, transport, "get-tasks", &getTasks{}, &getTasksResponse{})
+	defer c.Close()
+
+	if err := cmd.HandleRequest(context.Background(), &getTasks{}, &getTasksResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.dropConnections()
+
+	resp := &getTasksResponse{}
+	if err := cmd.HandleRequest(context.Background(), &getTasks{}, resp); err != nil {
+		t.Fatalf("request on a stale session: %s", err)
+	}
+	if len(resp.Tasks) != 1 {
+		t.Errorf("tasks %v, want one task", resp.Tasks)
+	}
+
+	accepted, authenticated, _ := srv.counts()
+	if accepted != 2 || authenticated != 2 {
+		t.Errorf("%d connections, %d authenticated, want the session redialled and authenticated once more", accepted, authenticated)
+	}
+}
+
+func TestXMLTransportAuthenticationTransportError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// hang up without replying to authenticate
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	transport := &XMLTransport{Address: ln.Addr().String(), Username: "admin", Password: "secret"}
+	c, cmd := newXMLCommand(t, transport, "get-tasks", &getTasks{}, &getTasksResponse{})
+	defer c.Close()
+
+	err = cmd.HandleRequest(context.Background(), &getTasks{}, &getTasksResponse{})
+	if !errors.Is(err, ErrTransport) || errors.Is(err, ErrAuth) {
+		t.Errorf("error %v, want ErrTransport and not ErrAuth", err)
+	}
+}